@@ -1,6 +1,12 @@
 package backup
 
 import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/hibare/GoS3Backup/internal/backup"
 	"github.com/spf13/cobra"
 )
@@ -10,6 +16,17 @@ var addCmd = &cobra.Command{
 	Short: "Perform a backup",
 	Long:  "",
 	Run: func(cmd *cobra.Command, args []string) {
-		backup.Backup()
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		state, err := backup.Run(ctx, backup.TriggerManual, false)
+		if err != nil {
+			slog.Error("Error performing backup", "error", err)
+			os.Exit(1)
+		}
+
+		if state.Status != backup.StatusSucceeded {
+			os.Exit(1)
+		}
 	},
 }
@@ -21,4 +21,10 @@ func init() {
 	BackupCmd.AddCommand(addCmd)
 	BackupCmd.AddCommand(purgeCmd)
 	BackupCmd.AddCommand(listCmd)
+	BackupCmd.AddCommand(historyCmd)
+	BackupCmd.AddCommand(statusCmd)
+	BackupCmd.AddCommand(lsCmd)
+	BackupCmd.AddCommand(findCmd)
+	BackupCmd.AddCommand(diffCmd)
+	BackupCmd.AddCommand(mountCmd)
 }
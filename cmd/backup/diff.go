@@ -0,0 +1,47 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <keyA> <keyB>",
+	Short: "Compare two backups",
+	Long:  "Report files added, removed and modified in backup keyB compared to keyA, with size deltas. Encrypted backups can't be compared",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := outputFlag(cmd)
+		d, err := backup.DiffSnapshots(args[0], args[1])
+		if err != nil {
+			return err
+		}
+
+		if format == constants.FormatTable {
+			fmt.Fprintf(cmd.OutOrStdout(), "\n%s -> %s: %d added, %d removed, %d modified (%.1f%%), %d unchanged, size %s\n",
+				d.From, d.To, d.Added, d.Removed, d.Modified, d.ModifiedRatio()*100, d.Unchanged, formatSizeDelta(format, d.SizeDelta))
+			if len(d.Changes) <= 0 {
+				return nil
+			}
+		}
+
+		var rows []table.Row
+		for _, c := range d.Changes {
+			rows = append(rows, table.Row{c.Change, c.Path, formatSize(format, c.OldSize), formatSize(format, c.NewSize), formatSizeDelta(format, c.Delta)})
+		}
+
+		return render(cmd.OutOrStdout(), format, d, table.Row{"Change", "Path", "Old Size", "New Size", "Delta"}, rows, table.ColumnConfig{
+			Name:     "Path",
+			WidthMax: 96,
+		})
+	},
+}
+
+func init() {
+	addOutputFlag(diffCmd)
+}
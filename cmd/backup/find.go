@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// findCmd represents the find command
+var findCmd = &cobra.Command{
+	Use:   "find <pattern>",
+	Short: "Search backups for files",
+	Long:  "Search all backups for files whose path or name matches the glob pattern, e.g. '*.conf' or 'data/etc/*'. Encrypted backups are skipped",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := outputFlag(cmd)
+		matches, err := backup.FindFiles(args[0])
+		if err != nil {
+			return err
+		}
+
+		if format == constants.FormatTable {
+			if len(matches) <= 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No files found")
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "\nTotal matches %d\n", len(matches))
+		}
+
+		var rows []table.Row
+		for _, m := range matches {
+			rows = append(rows, table.Row{m.Snapshot, m.Path, formatSize(format, m.Size), m.ModTime.Local().Format(time.DateTime)})
+		}
+
+		return render(cmd.OutOrStdout(), format, matches, table.Row{"Backup Key", "Path", "Size", "Modified"}, rows, table.ColumnConfig{
+			Name:     "Path",
+			WidthMax: 96,
+		})
+	},
+}
+
+func init() {
+	addOutputFlag(findCmd)
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historySince  string
+	historyFailed bool
+)
+
+// parseSince accepts a duration relative to now (e.g. 72h), a date or an RFC3339 timestamp.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show past backup runs",
+	Long:  "",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := outputFlag(cmd)
+		filter := backup.HistoryFilter{Failed: historyFailed}
+		if historySince != "" {
+			since, err := parseSince(historySince)
+			if err != nil {
+				return fmt.Errorf("invalid --since %q: %w", historySince, err)
+			}
+			filter.Since = since
+		}
+
+		runs, err := backup.History(filter)
+		if err != nil {
+			return err
+		}
+
+		if format == constants.FormatTable {
+			if len(runs) <= 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No runs found")
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "\nTotal runs %d\n", len(runs))
+		}
+
+		var rows []table.Row
+		for _, run := range runs {
+			var duration time.Duration
+			if run.FinishedAt != nil {
+				duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Second)
+			}
+
+			var okDirs, files, totalFiles int
+			var size int64
+			for _, d := range run.Dirs {
+				if d.Err == nil {
+					okDirs++
+				}
+				files += d.SuccessFiles
+				totalFiles += d.TotalFiles
+				size += d.Size
+			}
+
+			rows = append(rows, table.Row{
+				run.StartedAt.Local().Format(time.DateTime),
+				run.Trigger,
+				run.Status,
+				duration,
+				fmt.Sprintf("%d/%d", okDirs, run.TotalDirs),
+				fmt.Sprintf("%d/%d", files, totalFiles),
+				formatSize(format, size),
+				run.Error,
+			})
+		}
+
+		return render(cmd.OutOrStdout(), format, runs, table.Row{"Started", "Trigger", "Status", "Duration", "Dirs", "Files", "Size", "Error"}, rows, table.ColumnConfig{
+			Name:     "Error",
+			WidthMax: 64,
+		})
+	},
+}
+
+func init() {
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only show runs started since a duration ago (e.g. 72h), a date or an RFC3339 time")
+	historyCmd.Flags().BoolVar(&historyFailed, "failed", false, "only show failed runs")
+	addOutputFlag(historyCmd)
+}
@@ -2,9 +2,12 @@ package backup
 
 import (
 	"fmt"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/progress"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
@@ -14,32 +17,48 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List backups",
 	Long:  "",
-	Run: func(cmd *cobra.Command, args []string) {
-		backups, err := backup.ListBackups()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := outputFlag(cmd)
+		snapshots, err := backup.ListBackups()
 		if err != nil {
-			panic(err)
-		} else if len(backups) <= 0 {
-			fmt.Println("No backups found")
-		} else {
-			fmt.Printf("\nTotal backups %d\n", len(backups))
-			t := table.NewWriter()
-			t.SetOutputMirror(os.Stdout)
-			t.SetColumnConfigs([]table.ColumnConfig{
-				{
-					Name:     "Backup Key",
-					WidthMin: 20,
-					WidthMax: 64,
-				},
-			})
-			t.AppendHeader(table.Row{"#", "Backup Key"})
-
-			for i, backup := range backups {
+			return err
+		}
 
-				t.AppendRow([]interface{}{i + 1, backup})
-				t.AppendSeparator()
+		if format == constants.FormatTable {
+			if len(snapshots) <= 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No backups found")
+				return nil
 			}
+			var total int64
+			for _, s := range snapshots {
+				total += s.Size
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "\nTotal backups %d, %s stored\n", len(snapshots), progress.FormatBytes(total))
+		}
 
-			t.Render()
+		var rows []table.Row
+		for _, s := range snapshots {
+			rows = append(rows, table.Row{
+				s.Key,
+				s.Timestamp.Format(time.DateTime),
+				s.Age.Round(time.Second),
+				formatSize(format, s.Size),
+				s.Objects,
+				strings.Join(s.Dirs, ","),
+				s.Encrypted,
+				s.Complete,
+				s.Suspicious,
+			})
 		}
+
+		return render(cmd.OutOrStdout(), format, snapshots, table.Row{"Backup Key", "Timestamp", "Age", "Size", "Objects", "Dirs", "Encrypted", "Complete", "Suspicious"}, rows, table.ColumnConfig{
+			Name:     "Backup Key",
+			WidthMin: 20,
+			WidthMax: 64,
+		})
 	},
 }
+
+func init() {
+	addOutputFlag(listCmd)
+}
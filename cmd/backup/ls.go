@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls <key> [path]",
+	Short: "List files in a backup",
+	Long:  "List the files in the backup key, optionally only those at or below path (e.g. data/sub). Encrypted backups can't be listed",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := outputFlag(cmd)
+		var p string
+		if len(args) > 1 {
+			p = args[1]
+		}
+
+		files, err := backup.ListFiles(args[0], p)
+		if err != nil {
+			return err
+		}
+
+		if format == constants.FormatTable {
+			if len(files) <= 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "No files found")
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "\nTotal files %d\n", len(files))
+		}
+
+		var rows []table.Row
+		for _, f := range files {
+			rows = append(rows, table.Row{f.Path, formatSize(format, f.Size), f.ModTime.Local().Format(time.DateTime)})
+		}
+
+		return render(cmd.OutOrStdout(), format, files, table.Row{"Path", "Size", "Modified"}, rows, table.ColumnConfig{
+			Name:     "Path",
+			WidthMax: 96,
+		})
+	},
+}
+
+func init() {
+	addOutputFlag(lsCmd)
+}
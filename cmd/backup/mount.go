@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mountCacheDir     string
+	mountCacheSizeMiB int64
+)
+
+// mountCmd represents the mount command
+var mountCmd = &cobra.Command{
+	Use:   "mount <mountpoint>",
+	Short: "Mount backups as a read-only filesystem",
+	Long:  "Mount every backup in the bucket read-only at mountpoint as /<hostname>/<datetime>/<dir>/... using FUSE. Files are downloaded on first read, unmount with Ctrl+C",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if mountCacheSizeMiB <= 0 {
+			return fmt.Errorf("invalid cache size: %d MiB", mountCacheSizeMiB)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return backup.Mount(ctx, args[0], mountCacheDir, mountCacheSizeMiB*1024*1024)
+	},
+}
+
+func init() {
+	mountCmd.Flags().StringVar(&mountCacheDir, "cache-dir", "", "Dir to cache downloaded data in across mounts, a temporary dir removed on unmount by default")
+	mountCmd.Flags().Int64Var(&mountCacheSizeMiB, "cache-size", constants.DefaultMountCacheSizeMiB, "Max size of the cache in MiB, the least recently read data is evicted beyond it")
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var errUnknownOutput = errors.New("unknown output format")
+
+// addOutputFlag adds --output to cmd. With machine readable formats logs are
+// written to stderr, see the root command, so that the output can be parsed.
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", constants.FormatTable, "output format, table, json, yaml or csv")
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if format := outputFlag(cmd); !slices.Contains(constants.OutputFormats, format) {
+			return fmt.Errorf("%w: %s", errUnknownOutput, format)
+		}
+		return nil
+	}
+}
+
+// outputFlag returns the --output format of cmd.
+func outputFlag(cmd *cobra.Command) string {
+	format, _ := cmd.Flags().GetString("output")
+	return format
+}
+
+// formatSize returns n bytes humanized for tables, other formats keep the
+// exact number.
+func formatSize(format string, n int64) any {
+	if format != constants.FormatTable {
+		return n
+	}
+	return progress.FormatBytes(n)
+}
+
+// formatSizeDelta is formatSize for a signed change in size.
+func formatSizeDelta(format string, n int64) any {
+	if format != constants.FormatTable {
+		return n
+	}
+	if n < 0 {
+		return "-" + progress.FormatBytes(-n)
+	}
+	return "+" + progress.FormatBytes(n)
+}
+
+// render writes v as json or yaml, or header & rows as a table or csv to w.
+// yaml is derived from the json encoding so that both use the same keys.
+func render(w io.Writer, format string, v any, header table.Row, rows []table.Row, columns ...table.ColumnConfig) error {
+	switch format {
+	case constants.FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case constants.FormatYAML:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return err
+		}
+		resetStyle(&node)
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+
+	case constants.FormatCSV:
+		cw := csv.NewWriter(w)
+		records := [][]string{csvRecord(header)}
+		for _, row := range rows {
+			records = append(records, csvRecord(row))
+		}
+		return cw.WriteAll(records)
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(w)
+	t.SetColumnConfigs(columns)
+	t.AppendHeader(append(table.Row{"#"}, header...))
+	for i, row := range rows {
+		t.AppendRow(append(table.Row{i + 1}, row...))
+		t.AppendSeparator()
+	}
+	t.Render()
+	return nil
+}
+
+func csvRecord(row table.Row) []string {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = fmt.Sprint(v)
+	}
+	return record
+}
+
+// resetStyle drops the json flow & quoting styles so n encodes as block yaml.
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
@@ -4,6 +4,10 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package backup
 
 import (
+	"context"
+	"log/slog"
+	"os"
+
 	"github.com/hibare/GoS3Backup/internal/backup"
 	"github.com/spf13/cobra"
 )
@@ -14,6 +18,14 @@ var purgeCmd = &cobra.Command{
 	Short: "Purge old backups",
 	Long:  "",
 	Run: func(cmd *cobra.Command, args []string) {
-		backup.PurgeOldBackups()
+		_, release, err := backup.Lock(context.Background())
+		if err != nil {
+			slog.Error("Error acquiring lock", "error", err)
+			os.Exit(1)
+		}
+		defer release()
+
+		purge := backup.PurgeOldBackups()
+		backup.NotifyPurgeSummary(purge)
 	},
 }
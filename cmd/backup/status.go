@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show backup schedule & last run",
+	Long:  "",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := outputFlag(cmd)
+		status, err := backup.GetStatus()
+		if err != nil {
+			return err
+		}
+
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return constants.NotAvailable
+			}
+			return t.Local().Format(time.DateTime)
+		}
+
+		lastRun := constants.NotAvailable
+		if status.LastRun != nil {
+			lastRun = status.LastRun.StartedAt.Local().Format(time.DateTime) + " " + status.LastRun.Status
+		}
+
+		interrupted := constants.NotAvailable
+		if status.Interrupted != nil {
+			interrupted = status.Interrupted.Prefix
+		}
+
+		return render(cmd.OutOrStdout(), format, status, table.Row{"Field", "Value"}, []table.Row{
+			{"Hostname", status.Hostname},
+			{"Schedule", status.Cron + " " + status.Timezone},
+			{"Next Run", formatTime(status.NextRun)},
+			{"Last Run", lastRun},
+			{"Last Success", formatTime(status.LastSuccess)},
+			{"Interrupted", interrupted},
+		})
+	},
+}
+
+func init() {
+	addOutputFlag(statusCmd)
+}
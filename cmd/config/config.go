@@ -12,4 +12,7 @@ var ConfigCmd = &cobra.Command{
 func init() {
 	ConfigCmd.AddCommand(InitConfigCmd)
 	ConfigCmd.AddCommand(CleanConfigCmd)
+	ConfigCmd.AddCommand(ValidateConfigCmd)
+	ConfigCmd.AddCommand(ShowConfigCmd)
+	ConfigCmd.AddCommand(MigrateConfigCmd)
 }
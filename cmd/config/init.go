@@ -1,21 +1,337 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
 
+	commonUtils "github.com/hibare/GoCommon/v2/pkg/utils"
+	"github.com/hibare/GoS3Backup/internal/backup"
 	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/hibare/GoS3Backup/internal/validate"
 	"github.com/spf13/cobra"
 )
 
+var (
+	errRequired    = errors.New("required")
+	errNotPositive = errors.New("must be greater than 0")
+	errConfigFound = errors.New("config file already exists, use --force to overwrite")
+)
+
+var (
+	initFromFlags    bool
+	initForce        bool
+	initSkipS3Test   bool
+	initEndpoint     string
+	initRegion       string
+	initBucket       string
+	initAuthType     string
+	initProfile      string
+	initRoleARN      string
+	initAccessKey    string
+	initSecretKey    string
+	initPrefix       string
+	initDirs         []string
+	initCron         string
+	initRetention    int
+	initArchive      bool
+	initGPGKeyServer string
+	initGPGKeyID     string
+	initWebhook      string
+)
+
 var InitConfigCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize application config",
+	Long:  "Initialize application config interactively, or from flags with --from-flags",
+	// Skip loading the config, it is about to be replaced.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := config.InitConfig(); err != nil {
-			fmt.Println(err)
+		var c *config.Config
+		var err error
+		if initFromFlags {
+			c, err = configFromFlags()
 		} else {
-			fmt.Printf("\n\nConfig file path: %s\n", config.BC.ConfigFilePath)
-			fmt.Printf("Empty config file is loaded at above location. Edit config as per your needs.\n\n")
+			c, err = configFromPrompts(newPrompter(os.Stdin, os.Stdout))
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if err := config.WriteConfig(c); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+
+		fmt.Printf("\nConfig file path: %s\n", config.BC.ConfigFilePath)
+		fmt.Printf("Run `config validate` to check it, or edit it as per your needs.\n\n")
 	},
 }
+
+// configExists reports whether a non-empty config file is present.
+func configExists() bool {
+	info, err := os.Stat(config.BC.ConfigFilePath)
+	return err == nil && info.Size() > 0
+}
+
+// testS3 checks that the bucket of c is reachable and writable.
+func testS3(c *config.Config) error {
+	c.Backup.Hostname = commonUtils.GetHostname()
+	config.Set(c)
+
+	if err := backup.Ping(); err != nil {
+		return err
+	}
+	return backup.Probe()
+}
+
+func validCron(s string) error {
+	_, err := validate.Cron(s, constants.DefaultTimezone)
+	return err
+}
+
+func configFromFlags() (*config.Config, error) {
+	if configExists() && !initForce {
+		return nil, errConfigFound
+	}
+
+	c := config.DefaultConfig()
+	c.S3.Endpoint = initEndpoint
+	c.S3.Region = initRegion
+	c.S3.Bucket = initBucket
+	c.S3.Auth.Type = initAuthType
+	c.S3.Prefix = initPrefix
+	c.Backup.Dirs = initDirs
+	c.Backup.Cron = initCron
+	c.Backup.Retention.Count = initRetention
+	c.Backup.ArchiveDirs = initArchive
+
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", name, err))
+		}
+	}
+
+	check("endpoint", optional(validate.URL)(initEndpoint))
+	check("bucket", required(initBucket))
+	check("auth-type", validAuthType(initAuthType))
+	switch initAuthType {
+	case constants.S3AuthStatic:
+		c.S3.AccessKey = initAccessKey
+		c.S3.SecretKey = initSecretKey
+		check("access-key", required(initAccessKey))
+		check("secret-key", required(initSecretKey))
+	case constants.S3AuthProfile:
+		c.S3.Auth.Profile = initProfile
+	case constants.S3AuthAssumeRole:
+		c.S3.Auth.RoleARN = initRoleARN
+		check("role-arn", required(initRoleARN))
+	case constants.S3AuthWebIdentity:
+		c.S3.Auth.RoleARN = initRoleARN
+	}
+	if len(initDirs) == 0 {
+		check("dir", errRequired)
+	}
+	for _, dir := range initDirs {
+		check("dir", validate.Dir(dir))
+	}
+	check("cron", validCron(initCron))
+	if initRetention <= 0 {
+		check("retention", errNotPositive)
+	}
+
+	if initGPGKeyID != "" {
+		c.Backup.Encryption.Enabled = true
+		c.Backup.Encryption.GPG.KeyID = initGPGKeyID
+		c.Backup.Encryption.GPG.KeyServer = initGPGKeyServer
+		check("gpg-key-server", validate.URL(initGPGKeyServer))
+		if !initArchive {
+			check("gpg-key-id", errors.New("encryption requires --archive"))
+		}
+	}
+
+	if initWebhook != "" {
+		c.Notifiers.Enabled = true
+		c.Notifiers.Discord.Enabled = true
+		c.Notifiers.Discord.Webhook = initWebhook
+		check("discord-webhook", validate.URL(initWebhook))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if !initSkipS3Test {
+		if err := testS3(c); err != nil {
+			return nil, fmt.Errorf("s3 connectivity test failed: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+func validAuthType(s string) error {
+	if !slices.Contains(constants.S3AuthTypes, s) {
+		return fmt.Errorf("expected one of %s", strings.Join(constants.S3AuthTypes, ", "))
+	}
+	return nil
+}
+
+// askAuth asks how to authenticate with S3 and for the settings of that auth
+// type. Keys are only kept for static auth.
+func askAuth(p *prompter, c *config.Config) error {
+	auth := &c.S3.Auth
+	var err error
+	if auth.Type, err = p.ask(fmt.Sprintf("Auth type (%s)", strings.Join(constants.S3AuthTypes, ", ")), auth.Type, validAuthType); err != nil {
+		return err
+	}
+
+	if auth.Type != constants.S3AuthStatic {
+		c.S3.AccessKey, c.S3.SecretKey = "", ""
+	}
+
+	switch auth.Type {
+	case constants.S3AuthStatic:
+		if c.S3.AccessKey, err = p.ask("Access key", c.S3.AccessKey, required); err != nil {
+			return err
+		}
+		if c.S3.SecretKey, err = p.askSecret("Secret key", c.S3.SecretKey, required); err != nil {
+			return err
+		}
+
+	case constants.S3AuthProfile:
+		if auth.Profile, err = p.ask("Profile (empty for AWS_PROFILE or default)", auth.Profile, nil); err != nil {
+			return err
+		}
+
+	case constants.S3AuthAssumeRole:
+		if auth.RoleARN, err = p.ask("Role ARN", auth.RoleARN, required); err != nil {
+			return err
+		}
+		if auth.ExternalID, err = p.askSecret("External ID (empty for none)", auth.ExternalID, nil); err != nil {
+			return err
+		}
+
+	case constants.S3AuthWebIdentity:
+		if auth.RoleARN, err = p.ask("Role ARN (empty for AWS_ROLE_ARN)", auth.RoleARN, nil); err != nil {
+			return err
+		}
+		if auth.WebIdentityTokenFile, err = p.ask("Token file (empty for AWS_WEB_IDENTITY_TOKEN_FILE)", auth.WebIdentityTokenFile, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func configFromPrompts(p *prompter) (*config.Config, error) {
+	if configExists() {
+		overwrite, err := p.confirm(fmt.Sprintf("%s already exists, overwrite it?", config.BC.ConfigFilePath), false)
+		if err != nil || !overwrite {
+			return nil, errors.Join(err, errConfigFound)
+		}
+	}
+
+	c := config.DefaultConfig()
+	var err error
+
+	fmt.Fprintln(p.w, "\nS3")
+	for {
+		if c.S3.Endpoint, err = p.ask("Endpoint (empty for AWS)", c.S3.Endpoint, optional(validate.URL)); err != nil {
+			return nil, err
+		}
+		if c.S3.Region, err = p.ask("Region", "us-east-1", required); err != nil {
+			return nil, err
+		}
+		if c.S3.Bucket, err = p.ask("Bucket", c.S3.Bucket, required); err != nil {
+			return nil, err
+		}
+		if err := askAuth(p, c); err != nil {
+			return nil, err
+		}
+		if c.S3.Prefix, err = p.ask("Prefix", c.S3.Prefix, nil); err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintln(p.w, "Testing S3 connectivity...")
+		testErr := testS3(c)
+		if testErr == nil {
+			fmt.Fprintln(p.w, "  ok")
+			break
+		}
+
+		fmt.Fprintf(p.w, "  failed: %s\n", testErr)
+		retry, err := p.confirm("Re-enter S3 settings?", true)
+		if err != nil {
+			return nil, err
+		}
+		if !retry {
+			break
+		}
+	}
+
+	fmt.Fprintln(p.w, "\nBackup")
+	if c.Backup.Dirs, err = p.askList("Dirs to back up (comma separated)", validate.Dir); err != nil {
+		return nil, err
+	}
+	if c.Backup.Cron, err = p.ask("Schedule (cron, UTC)", c.Backup.Cron, validCron); err != nil {
+		return nil, err
+	}
+	if c.Backup.Retention.Count, err = p.askInt("Backups to keep", c.Backup.Retention.Count); err != nil {
+		return nil, err
+	}
+	if c.Backup.ArchiveDirs, err = p.confirm("Upload dirs as zip archives?", true); err != nil {
+		return nil, err
+	}
+
+	if c.Backup.ArchiveDirs {
+		if c.Backup.Encryption.Enabled, err = p.confirm("Encrypt archives with GPG?", false); err != nil {
+			return nil, err
+		}
+		if c.Backup.Encryption.Enabled {
+			if c.Backup.Encryption.GPG.KeyServer, err = p.ask("GPG key server", "https://keys.openpgp.org", validate.URL); err != nil {
+				return nil, err
+			}
+			if c.Backup.Encryption.GPG.KeyID, err = p.ask("GPG key ID", "", required); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fmt.Fprintln(p.w, "\nNotifiers")
+	if c.Notifiers.Discord.Webhook, err = p.ask("Discord webhook URL (empty to disable)", "", optional(validate.URL)); err != nil {
+		return nil, err
+	}
+	if c.Notifiers.Discord.Webhook != "" {
+		c.Notifiers.Enabled = true
+		c.Notifiers.Discord.Enabled = true
+	}
+
+	return c, nil
+}
+
+func init() {
+	InitConfigCmd.Flags().BoolVar(&initFromFlags, "from-flags", false, "create the config from flags instead of prompts")
+	InitConfigCmd.Flags().BoolVar(&initForce, "force", false, "overwrite an existing config file, with --from-flags")
+	InitConfigCmd.Flags().BoolVar(&initSkipS3Test, "skip-s3-test", false, "skip the S3 connectivity test, with --from-flags")
+	InitConfigCmd.Flags().StringVar(&initEndpoint, "endpoint", "", "S3 endpoint, empty for AWS")
+	InitConfigCmd.Flags().StringVar(&initRegion, "region", "us-east-1", "S3 region")
+	InitConfigCmd.Flags().StringVar(&initBucket, "bucket", "", "S3 bucket")
+	InitConfigCmd.Flags().StringVar(&initAuthType, "auth-type", constants.DefaultS3AuthType, fmt.Sprintf("S3 auth type, one of %s", strings.Join(constants.S3AuthTypes, ", ")))
+	InitConfigCmd.Flags().StringVar(&initAccessKey, "access-key", "", "S3 access key, with static auth")
+	InitConfigCmd.Flags().StringVar(&initSecretKey, "secret-key", "", "S3 secret key, with static auth")
+	InitConfigCmd.Flags().StringVar(&initProfile, "profile", "", "shared config profile, with profile auth")
+	InitConfigCmd.Flags().StringVar(&initRoleARN, "role-arn", "", "role to assume, with assume-role or web-identity auth")
+	InitConfigCmd.Flags().StringVar(&initPrefix, "prefix", "", "S3 prefix")
+	InitConfigCmd.Flags().StringSliceVar(&initDirs, "dir", nil, "dir to back up, repeatable")
+	InitConfigCmd.Flags().StringVar(&initCron, "cron", constants.DefaultCron, "backup schedule in cron format")
+	InitConfigCmd.Flags().IntVar(&initRetention, "retention", constants.DefaultRetentionCount, "number of backups to keep")
+	InitConfigCmd.Flags().BoolVar(&initArchive, "archive", true, "upload dirs as zip archives")
+	InitConfigCmd.Flags().StringVar(&initGPGKeyServer, "gpg-key-server", "https://keys.openpgp.org", "GPG key server")
+	InitConfigCmd.Flags().StringVar(&initGPGKeyID, "gpg-key-id", "", "GPG key ID, enables encryption")
+	InitConfigCmd.Flags().StringVar(&initWebhook, "discord-webhook", "", "Discord webhook URL, enables Discord notifications")
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+)
+
+func TestAskAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		secret      string
+		wantType    string
+		wantAccess  string
+		wantSecret  string
+		wantProfile string
+		wantRoleARN string
+	}{
+		{"static", "static\nAK\nnew-secret\n", "", "static", "AK", "new-secret", "", ""},
+		{"static keeps secret", "\n\n\n", "old-secret", "static", "old-access", "old-secret", "", ""},
+		{"profile drops keys", "profile\nwork\n", "old-secret", "profile", "", "", "work", ""},
+		{"default drops keys", "default\n", "old-secret", "default", "", "", "", ""},
+		{"assume-role requires role", "assume-role\n\narn:aws:iam::1:role/r\n\n", "", "assume-role", "", "", "", "arn:aws:iam::1:role/r"},
+		{"invalid type", "bogus\nimds\n", "", "imds", "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config.DefaultConfig()
+			if tt.secret != "" {
+				c.S3.AccessKey, c.S3.SecretKey = "old-access", tt.secret
+			}
+
+			var out bytes.Buffer
+			if err := askAuth(newPrompter(strings.NewReader(tt.input), &out), c); err != nil {
+				t.Fatalf("askAuth() error = %v", err)
+			}
+
+			got := c.S3
+			if got.Auth.Type != tt.wantType || got.AccessKey != tt.wantAccess || got.SecretKey != tt.wantSecret ||
+				got.Auth.Profile != tt.wantProfile || got.Auth.RoleARN != tt.wantRoleARN {
+				t.Errorf("askAuth() = %+v, want type %q keys %q/%q profile %q role %q",
+					got, tt.wantType, tt.wantAccess, tt.wantSecret, tt.wantProfile, tt.wantRoleARN)
+			}
+			if strings.Contains(out.String(), "old-secret") || strings.Contains(out.String(), "new-secret") {
+				t.Errorf("secret shown in prompts: %q", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var migrateWrite bool
+
+var MigrateConfigCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade application config to the current schema version",
+	Long:  "Upgrade the config file to the current schema version. The upgraded file is printed unless --write is set, which replaces it and keeps a backup copy",
+	// Skip loading the config, it may be too old or too new to load.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := config.Migrate(migrateWrite)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if result.From == result.To {
+			fmt.Printf("Config is already at version %d\n", result.To)
+			return
+		}
+
+		fmt.Printf("Migrating config from version %d to %d\n", result.From, result.To)
+		for _, step := range result.Applied {
+			fmt.Printf("  %s\n", step)
+		}
+
+		if !migrateWrite {
+			fmt.Printf("\n%s\n", result.Data)
+			fmt.Printf("Run with --write to update %s\n", config.BC.ConfigFilePath)
+			return
+		}
+
+		fmt.Printf("\nWrote %s\n", config.BC.ConfigFilePath)
+		fmt.Printf("Previous version saved to %s\n", result.Backup)
+	},
+}
+
+func init() {
+	MigrateConfigCmd.Flags().BoolVar(&migrateWrite, "write", false, "replace the config file, keeping a backup copy")
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// prompter asks questions on w and reads the answers from r, repeating a
+// question until its answer is valid. Secrets are read without echo if r is
+// a terminal.
+type prompter struct {
+	r  *bufio.Reader
+	w  io.Writer
+	fd int
+}
+
+func newPrompter(r io.Reader, w io.Writer) *prompter {
+	p := &prompter{r: bufio.NewReader(r), w: w, fd: -1}
+	if f, ok := r.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		p.fd = int(f.Fd())
+	}
+	return p
+}
+
+func (p *prompter) readLine() (string, error) {
+	line, err := p.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ask returns the answer to label, or def if the answer is empty.
+func (p *prompter) ask(label, def string, validate func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(p.w, "%s [%s]: ", label, def)
+		} else {
+			fmt.Fprintf(p.w, "%s: ", label)
+		}
+
+		answer, err := p.readLine()
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			answer = def
+		}
+
+		if validate != nil {
+			if err := validate(answer); err != nil {
+				fmt.Fprintf(p.w, "  invalid: %s\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// askSecret returns the answer to label without echoing it. The current
+// value is never shown, an empty answer keeps it.
+func (p *prompter) askSecret(label, current string, validate func(string) error) (string, error) {
+	for {
+		if current != "" {
+			fmt.Fprintf(p.w, "%s [keep current]: ", label)
+		} else {
+			fmt.Fprintf(p.w, "%s: ", label)
+		}
+
+		var answer string
+		if p.fd >= 0 {
+			b, err := term.ReadPassword(p.fd)
+			fmt.Fprintln(p.w)
+			if err != nil {
+				return "", err
+			}
+			answer = strings.TrimSpace(string(b))
+		} else {
+			var err error
+			if answer, err = p.readLine(); err != nil {
+				return "", err
+			}
+		}
+		if answer == "" {
+			answer = current
+		}
+
+		if validate != nil {
+			if err := validate(answer); err != nil {
+				fmt.Fprintf(p.w, "  invalid: %s\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// askInt returns the answer to label as a positive integer.
+func (p *prompter) askInt(label string, def int) (int, error) {
+	answer, err := p.ask(label, strconv.Itoa(def), positiveInt)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(answer)
+}
+
+// askList returns the comma separated answer to label, validating each item.
+func (p *prompter) askList(label string, validate func(string) error) ([]string, error) {
+	answer, err := p.ask(label, "", func(s string) error {
+		items := splitList(s)
+		if len(items) == 0 {
+			return errRequired
+		}
+		for _, item := range items {
+			if err := validate(item); err != nil {
+				return fmt.Errorf("%s: %w", item, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return splitList(answer), nil
+}
+
+// confirm asks a yes/no question.
+func (p *prompter) confirm(label string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+
+	for {
+		fmt.Fprintf(p.w, "%s [%s]: ", label, hint)
+		answer, err := p.readLine()
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func positiveInt(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return errNotPositive
+	}
+	return nil
+}
+
+func required(s string) error {
+	if s == "" {
+		return errRequired
+	}
+	return nil
+}
+
+// optional wraps validate to accept empty answers.
+func optional(validate func(string) error) func(string) error {
+	return func(s string) error {
+		if s == "" {
+			return nil
+		}
+		return validate(s)
+	}
+}
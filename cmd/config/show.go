@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/spf13/cobra"
+)
+
+var (
+	showFormat string
+	showReveal bool
+)
+
+var ShowConfigCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show effective application config",
+	Long:  "Show the config after defaults and environment overrides are applied, annotated with where each value came from",
+	Run: func(cmd *cobra.Command, args []string) {
+		out, err := config.Show(showFormat, showReveal)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+func init() {
+	ShowConfigCmd.Flags().StringVar(&showFormat, "format", constants.FormatYAML, "output format, yaml or json")
+	ShowConfigCmd.Flags().BoolVar(&showReveal, "reveal", false, "show secret values")
+}
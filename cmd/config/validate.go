@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hibare/GoS3Backup/internal/validate"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+var ValidateConfigCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate application config",
+	// Skip loading the config, LoadConfig exits on invalid settings before
+	// they can be reported.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		results := validate.Run()
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.SetColumnConfigs([]table.ColumnConfig{
+			{
+				Name:     "Detail",
+				WidthMax: 80,
+			},
+		})
+		t.AppendHeader(table.Row{"Check", "Status", "Detail"})
+
+		failed := 0
+		for _, r := range results {
+			if r.Status == validate.StatusFail {
+				failed++
+			}
+			t.AppendRow(table.Row{r.Name, strings.ToUpper(r.Status), r.Detail})
+		}
+		t.Render()
+
+		if validate.Failed(results) {
+			fmt.Printf("\n%d of %d checks failed\n", failed, len(results))
+			os.Exit(1)
+		}
+		fmt.Printf("\nAll %d checks passed\n", len(results))
+	},
+}
@@ -1,38 +1,64 @@
 package cmd
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/go-co-op/gocron"
 	commonLogger "github.com/hibare/GoCommon/v2/pkg/logger"
 	"github.com/hibare/GoS3Backup/cmd/backup"
 	configCmd "github.com/hibare/GoS3Backup/cmd/config"
+	"github.com/hibare/GoS3Backup/internal/api"
 	intBackup "github.com/hibare/GoS3Backup/internal/backup"
 	"github.com/hibare/GoS3Backup/internal/config"
 	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/hibare/GoS3Backup/internal/metrics"
+	"github.com/hibare/GoS3Backup/internal/notifiers"
 	"github.com/hibare/GoS3Backup/internal/version"
 	"github.com/spf13/cobra"
 )
 
+const backupJobTag = "backup"
+
 var rootCmd = &cobra.Command{
 	Use:     "GoS3Backup",
 	Short:   "Application to backup directories to S3",
 	Long:    "",
 	Version: version.CurrentVersion,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		config.LoadConfig(logOutput(cmd))
+		if err := notifiers.ValidateTemplates(); err != nil {
+			slog.Warn("Invalid notifier template, defaults will be used", "error", err)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-		s := gocron.NewScheduler(time.UTC)
+		if config.Get().Metrics.Enabled {
+			go func() {
+				if err := metrics.Serve(ctx, config.Get().Metrics.Listen); err != nil {
+					slog.Error("Error serving metrics", "error", err)
+				}
+			}()
+		}
 
-		// Schedule backup job
-		if _, err := s.Cron(config.Current.Backup.Cron).Do(func() {
-			intBackup.Backup()
-			intBackup.PurgeOldBackups()
-		}); err != nil {
-			slog.Error("Error setting up cron")
+		if config.Get().API.Enabled {
+			go func() {
+				if err := api.Serve(ctx, config.Get().API.Listen, config.Get().API.Token); err != nil {
+					slog.Error("Error serving API", "error", err)
+				}
+			}()
 		}
-		slog.Info("Scheduled backup job", "cron", config.Current.Backup.Cron)
+
+		s := gocron.NewScheduler(config.Get().Backup.Location)
+
+		// Schedule backup job
+		scheduleBackup(ctx, s)
 
 		// Schedule version check job
 		if _, err := s.Cron(constants.VersionCheckCron).Do(func() {
@@ -41,10 +67,85 @@ var rootCmd = &cobra.Command{
 			slog.Warn("Failed to schedule version check job")
 		}
 
-		s.StartBlocking()
+		s.StartAsync()
+
+		go func() {
+			if err := config.Watch(ctx, func() { reloadConfig(ctx, s) }); err != nil {
+				slog.Error("Error watching config", "error", err)
+			}
+		}()
+
+		go func() {
+			if err := intBackup.Resume(ctx); err != nil {
+				slog.Error("Error resuming interrupted backup", "error", err)
+			}
+			if err := intBackup.RunOnStart(ctx); err != nil {
+				slog.Error("Error running backup on start", "error", err)
+			}
+		}()
+
+		<-ctx.Done()
+		slog.Info("Shutting down")
+		s.Stop()
+		intBackup.Wait()
 	},
 }
 
+// scheduleBackup (re)schedules the backup job from the current config.
+func scheduleBackup(ctx context.Context, s *gocron.Scheduler) {
+	_ = s.RemoveByTag(backupJobTag)
+	s.ChangeLocation(config.Get().Backup.Location)
+
+	if _, err := s.Cron(config.Get().Backup.Cron).Tag(backupJobTag).Do(func() {
+		if err := intBackup.WaitJitter(ctx); err != nil {
+			return
+		}
+		if _, err := intBackup.Run(ctx, intBackup.TriggerCron, true); err != nil {
+			slog.Error("Error running backup job", "error", err)
+		}
+	}); err != nil {
+		slog.Error("Error setting up cron")
+	}
+	slog.Info("Scheduled backup job", "cron", config.Get().Backup.Cron, "timezone", config.Get().Backup.Timezone, "jitter", config.Get().Backup.Jitter)
+}
+
+// logOutput returns where cmd logs to, stderr if it prints machine readable
+// output that logs would corrupt.
+func logOutput(cmd *cobra.Command) io.Writer {
+	if f := cmd.Flags().Lookup("output"); f != nil && f.Value.String() != constants.FormatTable {
+		return cmd.ErrOrStderr()
+	}
+	return cmd.OutOrStdout()
+}
+
+// reloadConfig loads the config file and swaps it in between runs. On
+// failure the previous config is kept.
+func reloadConfig(ctx context.Context, s *gocron.Scheduler) {
+	c, err := config.Load()
+	if err != nil {
+		slog.Error("Error reloading config, keeping previous config", "error", err)
+		notifiers.NotifyConfigReloadFailure(config.BC.ConfigFilePath, err)
+		return
+	}
+
+	previous := config.Get()
+	intBackup.Exclusive(func() {
+		config.Apply(c)
+	})
+
+	if err := notifiers.ValidateTemplates(); err != nil {
+		slog.Warn("Invalid notifier template, defaults will be used", "error", err)
+	}
+
+	// Servers are bound at start
+	if c.Metrics != previous.Metrics || c.API != previous.API {
+		slog.Warn("Metrics and API changes take effect after a restart")
+	}
+
+	scheduleBackup(ctx, s)
+	slog.Info("Reloaded config")
+}
+
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
@@ -56,7 +157,7 @@ func init() {
 	rootCmd.AddCommand(configCmd.ConfigCmd)
 	rootCmd.AddCommand(backup.BackupCmd)
 
-	cobra.OnInitialize(commonLogger.InitDefaultLogger, config.LoadConfig)
+	cobra.OnInitialize(commonLogger.InitDefaultLogger)
 
 	initialVersionCheck := func() {
 		version.V.CheckUpdate()
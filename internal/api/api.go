@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	commonHandler "github.com/hibare/GoCommon/v2/pkg/http/handler"
+	commonMiddleware "github.com/hibare/GoCommon/v2/pkg/http/middleware"
+	"github.com/hibare/GoS3Backup/internal/backup"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// bearerAuth rejects requests without a matching bearer token.
+func bearerAuth(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get(commonMiddleware.AuthHeaderName), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			commonHttp.WriteErrorResponse(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if err := backup.Ping(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	commonHttp.WriteJsonResponse(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// createBackup starts runs bound to ctx rather than the request, so that
+// they outlive the request but stop on shutdown.
+func createBackup(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := backup.Start(ctx, backup.TriggerAPI)
+		if errors.Is(err, backup.ErrRunInProgress) {
+			commonHttp.WriteErrorResponse(w, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/runs/%s", state.ID))
+		commonHttp.WriteJsonResponse(w, http.StatusAccepted, state)
+	}
+}
+
+func listBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := backup.ListBackups()
+	if err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadGateway, err)
+		return
+	}
+
+	if backups == nil {
+		backups = []backup.Snapshot{}
+	}
+	commonHttp.WriteJsonResponse(w, http.StatusOK, map[string][]backup.Snapshot{"backups": backups})
+}
+
+func getRun(w http.ResponseWriter, r *http.Request) {
+	state, err := backup.GetRun(r.PathValue("id"))
+	if errors.Is(err, backup.ErrRunNotFound) {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	commonHttp.WriteJsonResponse(w, http.StatusOK, state)
+}
+
+// Handler returns the control API routes. Health endpoints are served
+// without authentication so they can be used by probes.
+func Handler(ctx context.Context, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", commonHandler.HealthCheck)
+	mux.HandleFunc("GET /readyz", readyz)
+	mux.Handle("POST /backups", bearerAuth(createBackup(ctx), token))
+	mux.Handle("GET /backups", bearerAuth(http.HandlerFunc(listBackups), token))
+	mux.Handle("GET /runs/{id}", bearerAuth(http.HandlerFunc(getRun), token))
+
+	return commonMiddleware.RequestLogger(commonMiddleware.BasicSecurity(mux, commonHttp.DefaultHTTPRequestSize))
+}
+
+// Serve starts the control API on addr. It blocks until the listener fails
+// or ctx is done.
+func Serve(ctx context.Context, addr, token string) error {
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      Handler(ctx, token),
+		ReadTimeout:  commonHttp.DefaultServerReadTimeout,
+		WriteTimeout: commonHttp.DefaultServerWriteTimeout,
+		IdleTimeout:  commonHttp.DefaultServerIdleTimeout,
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Error("Error shutting down API", "error", err)
+		}
+	})
+	defer stop()
+
+	slog.Info("Serving API", "addr", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/hibare/GoS3Backup/internal/metrics"
+	"github.com/hibare/GoS3Backup/internal/notifiers"
+)
+
+// Anomaly describes changes since the previous snapshot that exceed the
+// configured thresholds, as ransomware encrypting or deleting files would.
+type Anomaly struct {
+	Previous      string   `json:"previous"`
+	ModifiedRatio float64  `json:"modified_ratio"`
+	Entropy       float64  `json:"entropy"`
+	SizeChange    float64  `json:"size_change"`
+	Added         int      `json:"added"`
+	Removed       int      `json:"removed"`
+	Modified      int      `json:"modified"`
+	Unchanged     int      `json:"unchanged"`
+	Reasons       []string `json:"reasons"`
+}
+
+// entropy returns the Shannon entropy of data in bits per byte.
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var e float64
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(len(data))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// fileEntropy returns the entropy of the start of the file at path.
+func fileEntropy(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, constants.AnomalyEntropySampleBytes))
+	if err != nil {
+		return 0, err
+	}
+	return entropy(data), nil
+}
+
+// previousSnapshot returns the newest complete snapshot that isn't
+// suspicious, other than the one being written to.
+func previousSnapshot(exclude string) (*Snapshot, error) {
+	snapshots, err := ListBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range snapshots {
+		if s.Key != exclude && s.Complete && !s.Suspicious {
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkAnomaly compares the files in dirs with the previous snapshot and
+// returns an anomaly if any threshold is exceeded, nil otherwise.
+func checkAnomaly(s3 *commonS3.S3, dirs []string) (*Anomaly, error) {
+	prev, err := previousSnapshot(path.Base(s3.Prefix))
+	if err != nil || prev == nil {
+		return nil, err
+	}
+
+	prevFiles, exact, err := snapshotFiles(prev.Key)
+	if errors.Is(err, ErrSnapshotEncrypted) {
+		prevFiles, err = readIndex(prev.Key)
+		exact = true
+	}
+	if errors.Is(err, ErrNoIndex) {
+		slog.Error("Previous snapshot is encrypted and has no local file index, skipping anomaly check", "previous", prev.Key)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return compareSnapshot(prev.Key, prevFiles, exact, dirs, config.Get().Backup.Anomaly), nil
+}
+
+// compareSnapshot compares the files in dirs with prevFiles of the snapshot
+// previous and returns an anomaly if any threshold is exceeded, nil
+// otherwise.
+func compareSnapshot(previous string, prevFiles []FileEntry, exact bool, dirs []string, thresholds config.AnomalyConfig) *Anomaly {
+	// Only compare the dirs being backed up, e.g. when resuming
+	var files, compared []FileEntry
+	local := map[string]string{}
+	for _, dir := range dirs {
+		name := filepath.Base(filepath.Clean(dir))
+		for _, f := range dirFiles(dir) {
+			files = append(files, f)
+			local[f.Path] = filepath.Join(filepath.Dir(filepath.Clean(dir)), filepath.FromSlash(f.Path))
+		}
+		for _, f := range prevFiles {
+			if strings.HasPrefix(f.Path, name+"/") {
+				compared = append(compared, f)
+			}
+		}
+	}
+
+	if len(compared) < thresholds.MinFiles {
+		slog.Debug("Too few files to check for anomalies", "previous", previous, "files", len(compared))
+		return nil
+	}
+
+	d := diffFiles(compared, files, exact)
+	a := &Anomaly{
+		Previous:      previous,
+		ModifiedRatio: d.ModifiedRatio(),
+		Added:         d.Added,
+		Removed:       d.Removed,
+		Modified:      d.Modified,
+		Unchanged:     d.Unchanged,
+	}
+
+	var prevSize, size int64
+	for _, f := range compared {
+		prevSize += f.Size
+	}
+	for _, f := range files {
+		size += f.Size
+	}
+	if prevSize > 0 {
+		a.SizeChange = math.Abs(float64(size-prevSize)) / float64(prevSize)
+	}
+
+	// Encrypted files look random, sample the changed ones. A few new
+	// archives or images are high entropy too, so only once enough files
+	// changed.
+	if a.Modified+a.Added >= thresholds.MinChangedFiles {
+		var sampled int
+		var total float64
+		for _, c := range d.Changes {
+			if c.Change == ChangeRemoved || c.NewSize == 0 {
+				continue
+			}
+			if sampled >= constants.AnomalyEntropySampleFiles {
+				break
+			}
+
+			e, err := fileEntropy(local[c.Path])
+			if err != nil {
+				continue
+			}
+			total += e
+			sampled++
+		}
+		if sampled > 0 {
+			a.Entropy = total / float64(sampled)
+		}
+	}
+
+	a.Reasons = a.exceeded(thresholds)
+	if len(a.Reasons) == 0 {
+		return nil
+	}
+	return a
+}
+
+// exceeded returns a reason for every threshold a exceeds. Entropy only
+// counts once at least MinChangedFiles files were modified or added.
+func (a *Anomaly) exceeded(thresholds config.AnomalyConfig) []string {
+	var reasons []string
+	if a.ModifiedRatio > thresholds.MaxModifiedRatio {
+		reasons = append(reasons, fmt.Sprintf("%.0f%% of files modified or removed, threshold %.0f%%", a.ModifiedRatio*100, thresholds.MaxModifiedRatio*100))
+	}
+	if a.Modified+a.Added >= thresholds.MinChangedFiles && a.Entropy > thresholds.MaxEntropy {
+		reasons = append(reasons, fmt.Sprintf("changed files have an average entropy of %.2f bits per byte, threshold %.2f", a.Entropy, thresholds.MaxEntropy))
+	}
+	if a.SizeChange > thresholds.MaxSizeChange {
+		reasons = append(reasons, fmt.Sprintf("total size changed by %.0f%%, threshold %.0f%%", a.SizeChange*100, thresholds.MaxSizeChange*100))
+	}
+	return reasons
+}
+
+// guardAnomaly checks a run about to be backed up into the snapshot at the
+// prefix of s3. If it looks anomalous, the run is recorded as such so that
+// older snapshots aren't purged, a high priority notification is sent and
+// the anomaly is returned for the snapshot to be marked suspicious.
+func guardAnomaly(s3 *commonS3.S3, dirs []string) *Anomaly {
+	if !config.Get().Backup.Anomaly.Enabled {
+		return nil
+	}
+
+	a, err := checkAnomaly(s3, dirs)
+	if err != nil {
+		slog.Warn("Error checking for anomalies", "error", err)
+		return nil
+	}
+	if a == nil {
+		return nil
+	}
+
+	slog.Error("Backup looks anomalous, marking snapshot suspicious", "previous", a.Previous, "reasons", a.Reasons)
+	metrics.AnomaliesTotal.Inc()
+	trackAnomaly(a)
+
+	notifiers.NotifyAnomaly(notifiers.Anomaly{
+		Key:       s3.Prefix,
+		Previous:  a.Previous,
+		Reasons:   a.Reasons,
+		Added:     a.Added,
+		Removed:   a.Removed,
+		Modified:  a.Modified,
+		Unchanged: a.Unchanged,
+	})
+	return a
+}
+
+// markSuspicious stores a in the snapshot at the prefix of s3. It is only
+// done once something was uploaded, a marker alone would look like a
+// snapshot.
+func markSuspicious(s3 *commonS3.S3, a *Anomaly) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	_, err = awsS3.New(s3.Sess).PutObject(newObjectLock().putObject(&awsS3.PutObjectInput{
+		Bucket:      aws.String(s3.Bucket),
+		Key:         aws.String(s3.Prefix + constants.SuspiciousFileName),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}))
+	return err
+}
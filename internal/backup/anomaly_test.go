@@ -0,0 +1,181 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+)
+
+func TestEntropy(t *testing.T) {
+	all := make([]byte, 256*16)
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"single byte value", []byte("aaaaaaaa"), 0},
+		{"two values", []byte("abababab"), 1},
+		{"all byte values", all, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entropy(tt.data); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("entropy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnomalyExceeded(t *testing.T) {
+	thresholds := config.AnomalyConfig{
+		MinFiles:         10,
+		MaxModifiedRatio: 0.5,
+		MaxEntropy:       7.5,
+		MaxSizeChange:    0.5,
+		MinChangedFiles:  10,
+	}
+
+	tests := []struct {
+		name    string
+		anomaly Anomaly
+		want    int
+	}{
+		{"unchanged", Anomaly{Unchanged: 100}, 0},
+		{"modified ratio", Anomaly{ModifiedRatio: 0.8, Modified: 80, Unchanged: 20}, 1},
+		{"modified ratio at threshold", Anomaly{ModifiedRatio: 0.5, Modified: 50, Unchanged: 50}, 0},
+		{"one high entropy file", Anomaly{Entropy: 7.99, Added: 1, Unchanged: 100}, 0},
+		{"few high entropy files", Anomaly{Entropy: 7.99, Added: 5, Modified: 4, Unchanged: 100}, 0},
+		{"many high entropy files", Anomaly{Entropy: 7.99, Modified: 10, Unchanged: 100}, 1},
+		{"many low entropy files", Anomaly{Entropy: 4.2, Added: 30, Unchanged: 100}, 0},
+		{"size change", Anomaly{SizeChange: 0.9, Unchanged: 100}, 1},
+		{"everything", Anomaly{ModifiedRatio: 1, Entropy: 8, SizeChange: 1, Modified: 100}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.anomaly.exceeded(thresholds); len(got) != tt.want {
+				t.Errorf("exceeded() = %q, want %d reasons", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetainedKeys(t *testing.T) {
+	snapshots := func(suspicious ...bool) []Snapshot {
+		var s []Snapshot
+		for i, sus := range suspicious {
+			s = append(s, Snapshot{Key: string(rune('a' + i)), Suspicious: sus})
+		}
+		return s
+	}
+
+	tests := []struct {
+		name       string
+		snapshots  []Snapshot
+		retention  int
+		wantKept   []string
+		wantPurged []string
+	}{
+		{"none", nil, 3, nil, nil},
+		{"below retention", snapshots(false, false), 3, []string{"a", "b"}, nil},
+		{"above retention", snapshots(false, false, false, false), 2, []string{"a", "b"}, []string{"c", "d"}},
+		{"newest suspicious", snapshots(true, true, false, false, false), 2, []string{"a", "b", "c", "d"}, []string{"e"}},
+		{"suspicious in between", snapshots(false, true, false, true, false), 2, []string{"a", "b", "c"}, []string{"d", "e"}},
+		{"all suspicious", snapshots(true, true, true), 1, []string{"a", "b", "c"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, purged := retainedKeys(tt.snapshots, tt.retention)
+			if !slices.Equal(kept, tt.wantKept) || !slices.Equal(purged, tt.wantPurged) {
+				t.Errorf("retainedKeys() = %q, %q, want %q, %q", kept, purged, tt.wantKept, tt.wantPurged)
+			}
+		})
+	}
+}
+
+// setConfigRootDir makes dir the config root dir for the test.
+func setConfigRootDir(t *testing.T, dir string) {
+	t.Helper()
+	previous := config.BC.ConfigRootDir
+	t.Cleanup(func() { config.BC.ConfigRootDir = previous })
+	config.BC.ConfigRootDir = dir
+}
+
+func TestCompareEncryptedSnapshot(t *testing.T) {
+	setConfigRootDir(t, t.TempDir())
+	thresholds := config.AnomalyConfig{
+		MinFiles:         10,
+		MaxModifiedRatio: 0.5,
+		MaxEntropy:       7.5,
+		MaxSizeChange:    0.5,
+		MinChangedFiles:  10,
+	}
+
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.txt", i)), bytes.Repeat([]byte("plain text "), 1000), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The previous run's files are only in the local index
+	if _, err := readIndex("previous"); !errors.Is(err, ErrNoIndex) {
+		t.Fatalf("readIndex() error = %v, want %v", err, ErrNoIndex)
+	}
+	if err := writeIndex("previous", dirFiles(dir)); err != nil {
+		t.Fatal(err)
+	}
+	prevFiles, err := readIndex("previous")
+	if err != nil || len(prevFiles) != 20 {
+		t.Fatalf("readIndex() = %d files, %v, want 20", len(prevFiles), err)
+	}
+
+	if a := compareSnapshot("previous", prevFiles, true, []string{dir}, thresholds); a != nil {
+		t.Errorf("compareSnapshot() unchanged = %+v, want nil", a)
+	}
+
+	// Encrypt every file in place
+	later := time.Now().Add(time.Hour)
+	for i := range 20 {
+		name := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		data := make([]byte, 11000)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := compareSnapshot("previous", prevFiles, true, []string{dir}, thresholds)
+	if a == nil || a.Modified != 20 || a.Entropy < thresholds.MaxEntropy || len(a.Reasons) != 2 {
+		t.Errorf("compareSnapshot() encrypted = %+v, want 20 modified high entropy files", a)
+	}
+
+	removeIndex("previous")
+	if _, err := readIndex("previous"); !errors.Is(err, ErrNoIndex) {
+		t.Errorf("readIndex() after removeIndex() error = %v, want %v", err, ErrNoIndex)
+	}
+}
@@ -1,197 +1,418 @@
 package backup
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"log/slog"
 
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
 	commonGPG "github.com/hibare/GoCommon/v2/pkg/crypto/gpg"
-	commonDateTimes "github.com/hibare/GoCommon/v2/pkg/datetime"
 	commonFiles "github.com/hibare/GoCommon/v2/pkg/file"
 	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
 	"github.com/hibare/GoS3Backup/internal/config"
 	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/hibare/GoS3Backup/internal/metrics"
 	"github.com/hibare/GoS3Backup/internal/notifiers"
 )
 
 var (
 	ErrArchiving          = errors.New("error archiving")
 	ErrNoProcessableFiles = errors.New("no processable files")
+	ErrInvalidAuth        = errors.New("invalid s3 auth")
 )
 
-func Backup() {
-	s3 := commonS3.S3{
-		Endpoint:  config.Current.S3.Endpoint,
-		Region:    config.Current.S3.Region,
-		AccessKey: config.Current.S3.AccessKey,
-		SecretKey: config.Current.S3.SecretKey,
-		Bucket:    config.Current.S3.Bucket,
+// newS3 creates an S3 session with the prefix set to the host's backups,
+// timestamped for a new backup.
+func newS3(timestamped bool) (*commonS3.S3, error) {
+	s3 := &commonS3.S3{
+		Endpoint:  config.Get().S3.Endpoint,
+		Region:    config.Get().S3.Region,
+		AccessKey: config.Get().S3.AccessKey,
+		SecretKey: config.Get().S3.SecretKey,
+		Bucket:    config.Get().S3.Bucket,
 	}
 
-	s3.SetPrefix(config.Current.S3.Prefix, config.Current.Backup.Hostname, true)
+	s3.SetPrefix(config.Get().S3.Prefix, config.Get().Backup.Hostname, timestamped)
 
-	if err := s3.NewSession(); err != nil {
+	sess, err := cachedSession(config.Get().S3)
+	if err != nil {
 		slog.Error("Error creating session", "error", err)
-		os.Exit(1)
-		return
+		return s3, err
 	}
+	s3.Sess = sess
 
-	// Loop through individual backup dir & perform backup
-	for _, dir := range config.Current.Backup.Dirs {
-		slog.Info("Processing path", "path", dir)
-
-		if config.Current.Backup.ArchiveDirs {
-			slog.Info("Archiving dir", "dir", dir)
-			archivePath, totalFiles, totalDirs, successFiles, err := commonFiles.ArchiveDir(dir, nil)
-			if err != nil {
-				slog.Error("Error archiving", "error", err)
-				notifiers.NotifyBackupFailure(dir, totalDirs, totalFiles, err)
-				continue
-			}
+	return s3, nil
+}
 
-			if successFiles <= 0 {
-				slog.Error("No processable files", "dir", dir)
-				notifiers.NotifyBackupFailure(dir, totalDirs, totalFiles, ErrNoProcessableFiles)
-				continue
+// Backup backs up dirs into the snapshot at the prefix of s3. When ctx is
+// canceled the current dir gets the shutdown grace period to finish, after
+// which the finished dirs are returned with ErrInterrupted. resume skips
+// files of the first dir that were already uploaded. Runs that look like
+// ransomware compared with the previous snapshot are marked suspicious.
+func Backup(ctx context.Context, s3 *commonS3.S3, dirs []string, resume bool, last LastSuccess) ([]DirResult, error) {
+	var results []DirResult
+
+	uploadCtx, cancel := withGrace(ctx, config.Get().Backup.ShutdownGrace)
+	defer cancel()
+
+	if anomaly := guardAnomaly(s3, dirs); anomaly != nil {
+		defer func() {
+			if !slices.ContainsFunc(results, func(r DirResult) bool { return r.Err == nil }) {
+				return
 			}
-			slog.Info("Archived files", "successFiles", successFiles, "totalFiles", totalFiles, "archivePath", archivePath)
-
-			uploadPath := archivePath
-
-			if config.Current.Backup.Encryption.Enabled {
-				slog.Info("Encrypting archive", "archivePath", archivePath)
-				gpg, err := commonGPG.DownloadGPGPubKey(config.Current.Backup.Encryption.GPG.KeyID, config.Current.Backup.Encryption.GPG.KeyServer)
-				if err != nil {
-					slog.Error("Error downloading gpg key", "error", err)
-					notifiers.NotifyBackupFailure(dir, totalDirs, totalFiles, err)
-					continue
-				}
-
-				encryptedFilePath, err := gpg.EncryptFile(archivePath)
-				if err != nil {
-					slog.Error("Error encrypting file", "error", err)
-					notifiers.NotifyBackupFailure(dir, totalDirs, totalFiles, err)
-					continue
-				}
-
-				uploadPath = encryptedFilePath
-				slog.Info("Encrypted archive", "uploadPath", uploadPath)
-				os.Remove(archivePath)
+			if err := markSuspicious(s3, anomaly); err != nil {
+				slog.Error("Error marking snapshot suspicious", "error", err)
 			}
+		}()
+	}
 
-			slog.Info("Uploading file", "uploadPath", uploadPath)
-			key, err := s3.UploadFile(uploadPath)
-			if err != nil {
-				slog.Error("Uploading failed", "error", err)
-				notifiers.NotifyBackupFailure(dir, totalDirs, totalFiles, err)
-				continue
-			}
+	// Loop through individual backup dir & perform backup
+	for i, dir := range dirs {
+		if ctx.Err() != nil {
+			return results, ErrInterrupted
+		}
 
-			slog.Info("Uploaded file", "key", key, "successFiles", successFiles, "totalFiles", totalFiles, "uploadPath", uploadPath)
-			notifiers.NotifyBackupSuccess(dir, totalDirs, totalFiles, successFiles, key)
-			os.Remove(uploadPath)
-		} else {
-			slog.Info("Uploading dir", "dir", dir)
-			key, totalFiles, totalDirs, successFiles := s3.UploadDir(dir, nil)
+		slog.Info("Processing path", "path", dir)
+		start := time.Now()
+		trackDir(dir)
 
-			if successFiles <= 0 {
-				slog.Warn("No processable files", "dir", dir)
-				notifiers.NotifyBackupFailure(dir, totalDirs, totalFiles, ErrNoProcessableFiles)
-				continue
-			}
+		result := backupDir(uploadCtx, s3, dir, resume && i == 0)
+		if result.Err != nil && uploadCtx.Err() != nil {
+			slog.Warn("Backup interrupted", "dir", dir, "error", result.Err)
+			return results, ErrInterrupted
+		}
+
+		result.Duration = time.Since(start)
+		results = append(results, result)
+		trackResult(result)
 
-			slog.Warn("Uploaded files", "successFiles", successFiles, "totalFiles", totalFiles, "dir", dir)
-			notifiers.NotifyBackupSuccess(dir, totalDirs, totalFiles, successFiles, key)
+		metrics.FilesProcessedTotal.WithLabelValues(dir).Add(float64(result.SuccessFiles))
+		metrics.FilesFailedTotal.WithLabelValues(dir).Add(float64(result.TotalFiles - result.SuccessFiles))
+
+		if result.Err != nil {
+			notifiers.NotifyBackupFailure(result.event(last.Dirs[dir]), result.Err)
+			continue
 		}
 
+		metrics.UploadedBytesTotal.WithLabelValues(dir).Add(float64(result.Size))
+		metrics.LastSuccessTimestamp.WithLabelValues(dir).SetToCurrentTime()
+		notifiers.NotifyBackupSuccess(result.event(last.Dirs[dir]))
 	}
 	slog.Info("Backup job ran successfully")
+
+	return results, nil
 }
 
-func ListBackups() ([]string, error) {
-	var keys []string
+// withGrace returns a context that is canceled grace after ctx is done.
+func withGrace(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		slog.Warn("Shutting down, waiting for current dir to finish", "grace", grace)
+		time.AfterFunc(grace, cancel)
+	})
+
+	return graceCtx, func() {
+		stop()
+		cancel()
+	}
+}
 
-	s3 := commonS3.S3{
-		Endpoint:  config.Current.S3.Endpoint,
-		Region:    config.Current.S3.Region,
-		AccessKey: config.Current.S3.AccessKey,
-		SecretKey: config.Current.S3.SecretKey,
-		Bucket:    config.Current.S3.Bucket,
+func backupDir(ctx context.Context, s3 *commonS3.S3, dir string, resume bool) DirResult {
+	result := DirResult{Dir: dir}
+
+	if !config.Get().Backup.ArchiveDirs {
+		slog.Info("Uploading dir", "dir", dir)
+		uploadStart := time.Now()
+		key, totalFiles, totalDirs, successFiles, err := uploadDir(ctx, s3, dir, resume)
+		metrics.StageDuration.WithLabelValues(metrics.StageUpload).Observe(time.Since(uploadStart).Seconds())
+		result.Key, result.TotalFiles, result.TotalDirs, result.SuccessFiles = key, totalFiles, totalDirs, successFiles
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		if result.SuccessFiles <= 0 {
+			slog.Warn("No processable files", "dir", dir)
+			result.Err = ErrNoProcessableFiles
+			return result
+		}
+
+		result.Size = dirSize(dir)
+		result.Files = dirFiles(dir)
+		slog.Warn("Uploaded files", "successFiles", result.SuccessFiles, "totalFiles", result.TotalFiles, "dir", dir)
+		return result
+	}
+
+	slog.Info("Archiving dir", "dir", dir)
+	archiveStart := time.Now()
+	archivePath, totalFiles, totalDirs, successFiles, err := commonFiles.ArchiveDir(dir, nil)
+	if archivePath != "" {
+		defer os.Remove(archivePath)
+	}
+	metrics.StageDuration.WithLabelValues(metrics.StageArchive).Observe(time.Since(archiveStart).Seconds())
+	result.TotalFiles, result.TotalDirs, result.SuccessFiles = totalFiles, totalDirs, successFiles
+	if err != nil {
+		slog.Error("Error archiving", "error", err)
+		result.Err = err
+		return result
 	}
 
-	s3.SetPrefix(config.Current.S3.Prefix, config.Current.Backup.Hostname, false)
+	if successFiles <= 0 {
+		slog.Error("No processable files", "dir", dir)
+		result.Err = ErrNoProcessableFiles
+		return result
+	}
+	slog.Info("Archived files", "successFiles", successFiles, "totalFiles", totalFiles, "archivePath", archivePath)
 
-	if err := s3.NewSession(); err != nil {
-		slog.Error("Error creating session", "error", err)
-		return keys, err
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
 	}
 
-	slog.Info("prefix", "prefix", s3.Prefix)
+	uploadPath := archivePath
 
-	// Retrieve objects by prefix
-	keys, err := s3.ListObjectsAtPrefixRoot()
+	if config.Get().Backup.Encryption.Enabled {
+		slog.Info("Encrypting archive", "archivePath", archivePath)
+		encryptStart := time.Now()
+		gpg, err := commonGPG.DownloadGPGPubKey(config.Get().Backup.Encryption.GPG.KeyID, config.Get().Backup.Encryption.GPG.KeyServer)
+		if err != nil {
+			slog.Error("Error downloading gpg key", "error", err)
+			result.Err = err
+			return result
+		}
+
+		encryptedFilePath, err := gpg.EncryptFile(archivePath)
+		if encryptedFilePath != "" {
+			defer os.Remove(encryptedFilePath)
+		}
+		if err != nil {
+			slog.Error("Error encrypting file", "error", err)
+			result.Err = err
+			return result
+		}
+
+		metrics.StageDuration.WithLabelValues(metrics.StageEncrypt).Observe(time.Since(encryptStart).Seconds())
+		uploadPath = encryptedFilePath
+		slog.Info("Encrypted archive", "uploadPath", uploadPath)
+	}
+
+	slog.Info("Uploading file", "uploadPath", uploadPath)
+	uploadStart := time.Now()
+	key, err := uploadFile(ctx, s3, uploadPath)
+	metrics.StageDuration.WithLabelValues(metrics.StageUpload).Observe(time.Since(uploadStart).Seconds())
 	if err != nil {
-		slog.Error("Error listing objects", "error", err)
-		return keys, err
+		slog.Error("Uploading failed", "error", err)
+		result.Err = err
+		return result
 	}
 
-	if len(keys) == 0 {
-		slog.Info("No backups found")
-		return keys, nil
+	if info, err := os.Stat(uploadPath); err == nil {
+		result.Size = info.Size()
+	}
+	result.Key = key
+	result.Files = dirFiles(dir)
+
+	slog.Info("Uploaded file", "key", key, "successFiles", successFiles, "totalFiles", totalFiles, "uploadPath", uploadPath)
+
+	return result
+}
+
+// dirSize returns the total size of regular files under dir.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// dirFiles returns the regular files under dir with paths relative to its
+// parent, as they appear in a snapshot.
+func dirFiles(dir string) []FileEntry {
+	var files []FileEntry
+	parent := filepath.Dir(filepath.Clean(dir))
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(parent, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, FileEntry{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	return files
+}
+
+// Ping checks that the configured bucket is reachable.
+func Ping() error {
+	s3, err := newS3(false)
+	if err != nil {
+		return err
 	}
 
-	slog.Info("Found backups", "keys", len(keys))
+	_, err = awsS3.New(s3.Sess).HeadBucket(&awsS3.HeadBucketInput{
+		Bucket: aws.String(s3.Bucket),
+	})
+	return err
+}
+
+// Probe checks that objects can be written to and deleted from the host's
+// prefix.
+func Probe() error {
+	s3, err := newS3(false)
+	if err != nil {
+		return err
+	}
 
-	// Remove prefix from key to get datetime string
-	keys = s3.TrimPrefix(keys)
+	client := awsS3.New(s3.Sess)
+	key := s3.Prefix + constants.ProbeObjectPrefix + uuid.NewString()
+	if _, err := client.PutObject(&awsS3.PutObjectInput{
+		Bucket: aws.String(s3.Bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(constants.ProgramIdentifier),
+	}); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
 
-	// Sort datetime strings by descending order
-	sortedKeys := commonDateTimes.SortDateTimes(keys)
+	if _, err := client.DeleteObject(&awsS3.DeleteObjectInput{
+		Bucket: aws.String(s3.Bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
 
-	return sortedKeys, nil
+	return nil
 }
 
-func PurgeOldBackups() {
-	s3 := commonS3.S3{
-		Endpoint:  config.Current.S3.Endpoint,
-		Region:    config.Current.S3.Region,
-		AccessKey: config.Current.S3.AccessKey,
-		SecretKey: config.Current.S3.SecretKey,
-		Bucket:    config.Current.S3.Bucket,
+// retainedKeys splits snapshots, newest first, into the keys to keep and
+// those exceeding the retention count. Suspicious snapshots don't count
+// toward it, so that a run of them doesn't push out the good ones, and are
+// purged once older than the oldest good snapshot kept.
+func retainedKeys(snapshots []Snapshot, retention int) ([]string, []string) {
+	var kept, purged []string
+	var good int
+	for _, s := range snapshots {
+		if good >= retention {
+			purged = append(purged, s.Key)
+			continue
+		}
+
+		kept = append(kept, s.Key)
+		if !s.Suspicious {
+			good++
+		}
 	}
-	s3.SetPrefix(config.Current.S3.Prefix, config.Current.Backup.Hostname, false)
+	return kept, purged
+}
 
-	if err := s3.NewSession(); err != nil {
-		slog.Error("Error creating session", "error", err)
+func PurgeOldBackups() PurgeResult {
+	var result PurgeResult
+
+	s3, err := newS3(false)
+	if err != nil {
+		notifiers.NotifyBackupDeleteFailure(constants.NotAvailable, err)
+		result.Err = err
+		return result
 	}
 
-	backups, err := ListBackups()
+	snapshots, err := ListBackups()
 	if err != nil {
 		notifiers.NotifyBackupDeleteFailure(constants.NotAvailable, err)
-		return
+		result.Err = err
+		return result
 	}
+	result.Remaining = len(snapshots)
 
-	if len(backups) <= int(config.Current.Backup.RetentionCount) {
+	kept, keysToDelete := retainedKeys(snapshots, config.Get().Backup.Retention.Count)
+	if len(keysToDelete) == 0 {
 		slog.Info("No backups to delete")
-		return
+		checkRetention(&result, kept)
+		return result
 	}
 
-	keysToDelete := backups[config.Current.Backup.RetentionCount:]
-	slog.Info("Found backups to delete", "backups", len(keysToDelete), "retention", config.Current.Backup.RetentionCount, "keys", keysToDelete)
+	slog.Info("Found backups to delete", "backups", len(keysToDelete), "retention", config.Get().Backup.Retention.Count, "keys", keysToDelete)
 
 	// Delete datetime keys from S3 exceding retention count
 	for _, key := range keysToDelete {
 		slog.Info("Deleting backup", "key", key)
+		datetime := key
 		key = filepath.Join(s3.Prefix, key)
 
-		if err := s3.DeleteObjects(key, true); err != nil {
+		// Buckets with Object Lock are versioned and deletes only add
+		// delete markers, so versions are deleted & locked backups skipped
+		var locked string
+		var err error
+		if config.Get().Backup.ObjectLock.Enabled {
+			locked, err = deleteSnapshotVersions(s3, key+"/")
+		} else {
+			err = s3.DeleteObjects(key, true)
+		}
+		if err != nil {
 			slog.Error("Error deleting backup", "key", key, "error", err)
 			notifiers.NotifyBackupDeleteFailure(key, err)
+			metrics.PurgeDeletionsTotal.WithLabelValues(metrics.OutcomeFailure).Inc()
+			result.Failed = append(result.Failed, key)
+			kept = append(kept, datetime)
 			continue
 		}
+		if locked != "" {
+			slog.Warn("Skipping locked backup", "key", key, "reason", locked)
+			metrics.PurgeDeletionsTotal.WithLabelValues(metrics.OutcomeLocked).Inc()
+			result.Locked = append(result.Locked, key)
+			kept = append(kept, datetime)
+			continue
+		}
+		removeIndex(datetime)
+		metrics.PurgeDeletionsTotal.WithLabelValues(metrics.OutcomeSuccess).Inc()
+		result.Deleted = append(result.Deleted, key)
+		result.Remaining--
+	}
+
+	slog.Info("Deletion completed successfully", "deleted", len(result.Deleted), "locked", len(result.Locked), "remaining", result.Remaining)
+
+	if len(result.Deleted) > 0 {
+		notifiers.NotifyPurgeSuccess(result.purge())
+	}
+	checkRetention(&result, kept)
+
+	return result
+}
+
+// checkRetention warns when fewer backups remain than expected from the
+// schedule and retention count, e.g. because backups silently stopped.
+func checkRetention(result *PurgeResult, kept []string) {
+	metrics.SnapshotsStored.Set(float64(result.Remaining))
+	result.Expected = expectedBackups(kept)
+	if result.Remaining >= result.Expected {
+		return
 	}
 
-	slog.Info("Deletion completed successfully")
+	slog.Warn("Backups below retention target", "remaining", result.Remaining, "expected", result.Expected, "retention", config.Get().Backup.Retention.Count)
+	notifiers.NotifyRetentionWarning(result.purge())
 }
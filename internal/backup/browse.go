@@ -0,0 +1,266 @@
+package backup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	commonGPG "github.com/hibare/GoCommon/v2/pkg/crypto/gpg"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+var (
+	ErrSnapshotNotFound  = errors.New("snapshot not found")
+	ErrSnapshotEncrypted = errors.New("snapshot is encrypted, its files can't be listed")
+)
+
+// Match is a file found in a snapshot.
+type Match struct {
+	Snapshot string `json:"snapshot"`
+	FileEntry
+}
+
+// objectReader reads an object with range requests so that e.g. the index
+// of a zip archive can be read without downloading the archive.
+type objectReader struct {
+	client *awsS3.S3
+	bucket string
+	key    string
+	size   int64
+}
+
+func (r *objectReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), r.size)
+	out, err := r.client.GetObject(&awsS3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+
+	n, err := io.ReadFull(out.Body, p[:end-off])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readManifest returns the manifest of the snapshot at prefix, or nil if it
+// has none.
+func readManifest(client *awsS3.S3, bucket, prefix string) (*Manifest, error) {
+	out, err := client.GetObject(&awsS3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(prefix + constants.ManifestFileName),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == awsS3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(out.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SnapshotFiles returns the files in the snapshot key. They are taken from
+// the manifest, or for snapshots without one from the zip archive indexes
+// and object listing. Encrypted snapshots can't be listed, encrypted
+// archives of snapshots without a manifest are skipped.
+func SnapshotFiles(key string) ([]FileEntry, error) {
+	files, _, err := snapshotFiles(key)
+	return files, err
+}
+
+// snapshotFiles is SnapshotFiles, also reporting whether the files came from
+// the manifest and so have actual mtimes.
+func snapshotFiles(key string) ([]FileEntry, bool, error) {
+	s3, err := newS3(false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	client := awsS3.New(s3.Sess)
+	prefix := s3.Prefix + key + "/"
+
+	manifest, err := readManifest(client, s3.Bucket, prefix)
+	if err != nil {
+		return nil, false, err
+	}
+	if manifest != nil && manifest.Encrypted {
+		return nil, false, fmt.Errorf("%w: %s", ErrSnapshotEncrypted, key)
+	}
+	if manifest != nil && manifest.Files != nil {
+		return manifest.Files, true, nil
+	}
+
+	var files []FileEntry
+	var objects []*awsS3.Object
+	if err := client.ListObjectsV2Pages(&awsS3.ListObjectsV2Input{
+		Bucket: aws.String(s3.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *awsS3.ListObjectsV2Output, _ bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	}); err != nil {
+		return nil, false, err
+	}
+	if len(objects) == 0 {
+		return nil, false, fmt.Errorf("%w: %s", ErrSnapshotNotFound, key)
+	}
+
+	for _, obj := range objects {
+		name := strings.TrimPrefix(aws.StringValue(obj.Key), prefix)
+		switch {
+		case name == constants.ManifestFileName, name == constants.SuspiciousFileName:
+			continue
+
+		case strings.HasSuffix(name, "."+commonGPG.GPGPrefix):
+			slog.Warn("Skipping encrypted archive without manifest", "key", aws.StringValue(obj.Key))
+
+		case strings.HasSuffix(name, ".zip") && !strings.Contains(name, "/"):
+			entries, err := zipFiles(client, s3.Bucket, obj)
+			if err != nil {
+				return nil, false, fmt.Errorf("reading %s: %w", aws.StringValue(obj.Key), err)
+			}
+			files = append(files, entries...)
+
+		default:
+			// Uploaded as is, the upload time stands in for the mtime
+			files = append(files, FileEntry{
+				Path:    name,
+				Size:    aws.Int64Value(obj.Size),
+				ModTime: aws.TimeValue(obj.LastModified),
+			})
+		}
+	}
+
+	return files, false, nil
+}
+
+// zipMember is a file in a zip archive and its entry in the snapshot.
+type zipMember struct {
+	FileEntry
+	file *zip.File
+}
+
+// zipMembers lists the files in the zip archive of dir read from r from its
+// central directory, with paths below dir.
+func zipMembers(r io.ReaderAt, size int64, dir string, uploaded time.Time) ([]zipMember, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []zipMember
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		// Archives don't record mtimes, fall back to the upload time
+		modTime := f.Modified
+		if modTime.IsZero() || modTime.Year() <= 1980 {
+			modTime = uploaded
+		}
+
+		members = append(members, zipMember{
+			FileEntry: FileEntry{
+				Path:    path.Join(dir, f.Name),
+				Size:    int64(f.UncompressedSize64),
+				ModTime: modTime,
+			},
+			file: f,
+		})
+	}
+	return members, nil
+}
+
+// zipFiles lists the files in the zip archive obj from its central
+// directory.
+func zipFiles(client *awsS3.S3, bucket string, obj *awsS3.Object) ([]FileEntry, error) {
+	r := &objectReader{client: client, bucket: bucket, key: aws.StringValue(obj.Key), size: aws.Int64Value(obj.Size)}
+	members, err := zipMembers(r, r.size, strings.TrimSuffix(path.Base(r.key), ".zip"), aws.TimeValue(obj.LastModified))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileEntry, 0, len(members))
+	for _, m := range members {
+		files = append(files, m.FileEntry)
+	}
+	return files, nil
+}
+
+// ListFiles returns the files in the snapshot key at or below p.
+func ListFiles(key, p string) ([]FileEntry, error) {
+	files, err := SnapshotFiles(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return files, nil
+	}
+
+	var matched []FileEntry
+	for _, f := range files {
+		if f.Path == p || strings.HasPrefix(f.Path, p+"/") {
+			matched = append(matched, f)
+		}
+	}
+	return matched, nil
+}
+
+// FindFiles searches all snapshots, newest first, for files whose path or
+// name matches the glob pattern.
+func FindFiles(pattern string) ([]Match, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	keys, err := listBackupKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Match
+	for _, key := range keys {
+		files, err := SnapshotFiles(key)
+		if err != nil {
+			slog.Warn("Skipping unreadable snapshot", "key", key, "error", err)
+			continue
+		}
+
+		for _, f := range files {
+			byPath, _ := path.Match(pattern, f.Path)
+			byName, _ := path.Match(pattern, path.Base(f.Path))
+			if byPath || byName {
+				matches = append(matches, Match{Snapshot: key, FileEntry: f})
+			}
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,297 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// blockCache keeps what mounted snapshots read from S3 on disk, so that
+// files read repeatedly are only downloaded once. Once the cache grows past
+// max bytes the least recently used files are evicted, reading a cached file
+// bumps its mtime.
+type blockCache struct {
+	dir string
+	max int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+// newBlockCache returns a cache in dir of at most max bytes, counting what
+// is already cached in dir.
+func newBlockCache(dir string, max int64) (*blockCache, error) {
+	c := &blockCache{dir: dir, max: max}
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		c.size += f.size
+	}
+	return c, nil
+}
+
+type cachedFile struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+// files returns the files in the cache.
+func (c *blockCache) files() ([]cachedFile, error) {
+	var files []cachedFile
+	err := filepath.WalkDir(c.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		files = append(files, cachedFile{name: name, size: info.Size(), mtime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// open opens the cached file name and marks it as recently used.
+func (c *blockCache) open(name string) (*os.File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(name, now, now); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Debug("Error marking cached file as used", "name", name, "error", err)
+	}
+	return f, nil
+}
+
+// remove deletes the cached file name of size bytes, e.g. one found
+// truncated.
+func (c *blockCache) remove(name string, size int64) {
+	if err := os.Remove(name); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Error removing cached file", "name", name, "error", err)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size -= size
+}
+
+// openSized opens the cached file name like open if it has size bytes. A
+// file of another size, e.g. one cut short by a full disk, is removed and
+// reported as missing so that it is fetched again.
+func (c *blockCache) openSized(name string, size int64) (*os.File, error) {
+	f, err := c.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() == size {
+		return f, nil
+	}
+
+	f.Close()
+	slog.Warn("Discarding cached file of unexpected size", "name", name, "size", info.Size(), "expected", size)
+	c.remove(name, info.Size())
+	return nil, fs.ErrNotExist
+}
+
+// evict removes the least recently used files until the cache is down to
+// 90% of max bytes, so that not every store has to evict. keep is never
+// removed. Files still open keep being readable.
+func (c *blockCache) evict(keep string) {
+	files, err := c.files()
+	if err != nil {
+		slog.Warn("Error listing cache for eviction", "dir", c.dir, "error", err)
+		return
+	}
+	slices.SortFunc(files, func(a, b cachedFile) int {
+		return a.mtime.Compare(b.mtime)
+	})
+
+	c.size = 0
+	for _, f := range files {
+		c.size += f.size
+	}
+
+	for _, f := range files {
+		if c.size <= c.max/10*9 {
+			break
+		}
+		if f.name == keep {
+			continue
+		}
+		if err := os.Remove(f.name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Error evicting cached file", "name", f.name, "error", err)
+			continue
+		}
+		c.size -= f.size
+	}
+}
+
+// cacheID returns a name for parts in the cache that is safe to use as a
+// file name.
+func cacheID(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// store writes data to name, going through a temporary file so that readers
+// never see a partial file.
+func (c *blockCache) store(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size += n
+	if c.size > c.max {
+		c.evict(name)
+	}
+	return nil
+}
+
+// extract returns the contents of the compressed zip member f of the
+// archive id, decompressing it into the cache on first use. The caller
+// closes the file.
+func (c *blockCache) extract(id string, f *zip.File) (*os.File, error) {
+	name := filepath.Join(c.dir, "files", cacheID(id, f.Name))
+	if file, err := c.openSized(name, int64(f.UncompressedSize64)); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	if err := c.store(name, rc); err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+// cachedReader reads an object in blocks of constants.MountCacheBlockSize
+// through the cache, fetching missing blocks with range requests.
+type cachedReader struct {
+	obj   *objectReader
+	cache *blockCache
+	id    string
+}
+
+func newCachedReader(obj *objectReader, cache *blockCache) *cachedReader {
+	return &cachedReader{
+		obj:   obj,
+		cache: cache,
+		id:    cacheID(obj.bucket, obj.key, strconv.FormatInt(obj.size, 10)),
+	}
+}
+
+func (r *cachedReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.obj.size {
+			return n, io.EOF
+		}
+
+		idx := pos / constants.MountCacheBlockSize
+		c, err := r.readBlock(idx, p[n:], pos-idx*constants.MountCacheBlockSize)
+		if err != nil {
+			return n, err
+		}
+		if c == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += c
+	}
+	return n, nil
+}
+
+// readBlock reads block idx into p from off within the block.
+func (r *cachedReader) readBlock(idx int64, p []byte, off int64) (int, error) {
+	start := idx * constants.MountCacheBlockSize
+	size := min(constants.MountCacheBlockSize, r.obj.size-start)
+
+	name := filepath.Join(r.cache.dir, "blocks", r.id, strconv.FormatInt(idx, 10))
+	if f, err := r.cache.openSized(name, size); err == nil {
+		defer f.Close()
+
+		// The last block is short, reading past it is left to ReadAt
+		n, err := f.ReadAt(p, off)
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		return n, err
+	}
+
+	data := make([]byte, size)
+	if _, err := r.obj.ReadAt(data, start); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	if err := r.cache.store(name, bytes.NewReader(data)); err != nil {
+		slog.Warn("Error caching block", "key", r.obj.key, "block", idx, "error", err)
+	}
+	return copy(p, data[off:]), nil
+}
@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+func TestBlockCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newBlockCache(dir, 30)
+	if err != nil {
+		t.Fatalf("newBlockCache() error = %v", err)
+	}
+
+	name := func(n string) string { return filepath.Join(dir, "blocks", n) }
+	store := func(n string, age time.Duration) {
+		t.Helper()
+		if err := c.store(name(n), strings.NewReader("0123456789")); err != nil {
+			t.Fatalf("store(%s) error = %v", n, err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(name(n), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store("a", 3*time.Hour)
+	store("b", 2*time.Hour)
+	store("c", time.Hour)
+
+	// Reading a marks it as used, leaving b and c the least recently used
+	f, err := c.open(name("a"))
+	if err != nil {
+		t.Fatalf("open(a) error = %v", err)
+	}
+	f.Close()
+
+	// 40 bytes exceed the max, eviction goes down to 27 bytes
+	store("d", 0)
+
+	for n, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+		_, err := os.Stat(name(n))
+		if got := err == nil; got != want {
+			t.Errorf("%s cached = %v, want %v", n, got, want)
+		}
+	}
+	if c.size != 20 {
+		t.Errorf("size = %d, want 20", c.size)
+	}
+
+	reopened, err := newBlockCache(dir, 30)
+	if err != nil {
+		t.Fatalf("newBlockCache() error = %v", err)
+	}
+	if reopened.size != 20 {
+		t.Errorf("reopened size = %d, want 20", reopened.size)
+	}
+}
+
+func TestBlockCacheKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newBlockCache(dir, 5)
+	if err != nil {
+		t.Fatalf("newBlockCache() error = %v", err)
+	}
+
+	name := filepath.Join(dir, "files", "big")
+	if err := c.store(name, strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("file larger than the cache was evicted: %v", err)
+	}
+}
+
+// newTestObject returns a reader of data served by a stand-in for S3 and
+// a counter of the range requests made.
+func newTestObject(t *testing.T, data []byte) (*objectReader, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "object", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		Credentials:      credentials.NewStaticCredentials("AK", "SK", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &objectReader{client: awsS3.New(sess), bucket: "b", key: "k", size: int64(len(data))}, &requests
+}
+
+func TestCachedReaderTruncatedBlock(t *testing.T) {
+	data := make([]byte, constants.MountCacheBlockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	obj, requests := newTestObject(t, data)
+
+	cache, err := newBlockCache(t.TempDir(), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newCachedReader(obj, cache)
+
+	read := func() {
+		t.Helper()
+		done := make(chan struct{})
+		p := make([]byte, len(data))
+		var n int
+		var err error
+		go func() {
+			defer close(done)
+			n, err = r.ReadAt(p, 0)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("ReadAt() did not return")
+		}
+		if err != nil || n != len(data) || !bytes.Equal(p, data) {
+			t.Fatalf("ReadAt() = %d, %v, want %d bytes of the object", n, err, len(data))
+		}
+	}
+
+	read()
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("first read made %d requests, want 2", got)
+	}
+
+	read()
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("cached read made %d requests, want none", got-2)
+	}
+
+	// A block cut short, e.g. by a full disk, is fetched again
+	first := filepath.Join(cache.dir, "blocks", r.id, "0")
+	if err := os.Truncate(first, 10); err != nil {
+		t.Fatal(err)
+	}
+	read()
+	if got := requests.Load(); got != 3 {
+		t.Errorf("read of truncated block made %d requests, want 1", got-2)
+	}
+	if info, err := os.Stat(first); err != nil || info.Size() != constants.MountCacheBlockSize {
+		t.Errorf("block after refetch = %v, %v, want %d bytes", info, err, constants.MountCacheBlockSize)
+	}
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Kinds of change between snapshots.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Change is a file that differs between two snapshots.
+type Change struct {
+	Change  string `json:"change"`
+	Path    string `json:"path"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+	Delta   int64  `json:"delta"`
+}
+
+// Diff describes how the files of snapshot To differ from those of From.
+type Diff struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Added     int      `json:"added"`
+	Removed   int      `json:"removed"`
+	Modified  int      `json:"modified"`
+	Unchanged int      `json:"unchanged"`
+	SizeDelta int64    `json:"size_delta"`
+	Changes   []Change `json:"changes"`
+}
+
+// ModifiedRatio returns the fraction of files of From that were modified or
+// removed.
+func (d Diff) ModifiedRatio() float64 {
+	total := d.Modified + d.Removed + d.Unchanged
+	if total == 0 {
+		return 0
+	}
+	return float64(d.Modified+d.Removed) / float64(total)
+}
+
+// diffFiles compares the files of two snapshots. A file is modified if its
+// size changed or, when compareTimes is set, its mtime changed.
+func diffFiles(from, to []FileEntry, compareTimes bool) Diff {
+	var d Diff
+
+	old := make(map[string]FileEntry, len(from))
+	for _, f := range from {
+		old[f.Path] = f
+	}
+
+	for _, f := range to {
+		o, ok := old[f.Path]
+		delete(old, f.Path)
+
+		switch {
+		case !ok:
+			d.Added++
+			d.Changes = append(d.Changes, Change{Change: ChangeAdded, Path: f.Path, NewSize: f.Size, Delta: f.Size})
+		case o.Size != f.Size || (compareTimes && !o.ModTime.Equal(f.ModTime)):
+			d.Modified++
+			d.Changes = append(d.Changes, Change{Change: ChangeModified, Path: f.Path, OldSize: o.Size, NewSize: f.Size, Delta: f.Size - o.Size})
+		default:
+			d.Unchanged++
+		}
+	}
+
+	for _, o := range old {
+		d.Removed++
+		d.Changes = append(d.Changes, Change{Change: ChangeRemoved, Path: o.Path, OldSize: o.Size, Delta: -o.Size})
+	}
+
+	for _, c := range d.Changes {
+		d.SizeDelta += c.Delta
+	}
+	slices.SortFunc(d.Changes, func(a, b Change) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+
+	return d
+}
+
+// DiffSnapshots compares the files of snapshot from with those of snapshot
+// to. Mtimes are only compared if both snapshots have manifests, otherwise
+// they are upload times.
+func DiffSnapshots(from, to string) (Diff, error) {
+	fromFiles, fromManifest, err := snapshotFiles(from)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	toFiles, toManifest, err := snapshotFiles(to)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	d := diffFiles(fromFiles, toFiles, fromManifest && toManifest)
+	d.From, d.To = from, to
+	return d, nil
+}
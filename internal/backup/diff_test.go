@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffFiles(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	from := []FileEntry{
+		{Path: "d/same", Size: 10, ModTime: t1},
+		{Path: "d/grown", Size: 10, ModTime: t1},
+		{Path: "d/touched", Size: 10, ModTime: t1},
+		{Path: "d/removed", Size: 5, ModTime: t1},
+	}
+	to := []FileEntry{
+		{Path: "d/same", Size: 10, ModTime: t1},
+		{Path: "d/grown", Size: 30, ModTime: t2},
+		{Path: "d/touched", Size: 10, ModTime: t2},
+		{Path: "d/added", Size: 7, ModTime: t2},
+	}
+
+	tests := []struct {
+		name         string
+		compareTimes bool
+		want         Diff
+	}{
+		{"sizes only", false, Diff{Added: 1, Removed: 1, Modified: 1, Unchanged: 2, SizeDelta: 22}},
+		{"with mtimes", true, Diff{Added: 1, Removed: 1, Modified: 2, Unchanged: 1, SizeDelta: 22}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := diffFiles(from, to, tt.compareTimes)
+			if d.Added != tt.want.Added || d.Removed != tt.want.Removed || d.Modified != tt.want.Modified ||
+				d.Unchanged != tt.want.Unchanged || d.SizeDelta != tt.want.SizeDelta {
+				t.Errorf("diffFiles() = %+v, want %+v", d, tt.want)
+			}
+			if len(d.Changes) != d.Added+d.Removed+d.Modified {
+				t.Errorf("diffFiles() has %d changes, want %d", len(d.Changes), d.Added+d.Removed+d.Modified)
+			}
+		})
+	}
+
+	if r := diffFiles(from, to, true).ModifiedRatio(); r != 0.75 {
+		t.Errorf("ModifiedRatio() = %v, want 0.75", r)
+	}
+}
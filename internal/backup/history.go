@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// HistoryFilter selects runs returned by History.
+type HistoryFilter struct {
+	// Since excludes runs started before it, if set.
+	Since time.Time
+	// Failed only returns failed runs.
+	Failed bool
+}
+
+func historyFilePath() string {
+	return filepath.Join(config.BC.ConfigRootDir, constants.HistoryFileName)
+}
+
+// appendHistory records a finished run in the history file.
+func appendHistory(state RunState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		slog.Error("Error encoding run history", "error", err)
+		return
+	}
+
+	f, err := os.OpenFile(historyFilePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("Error opening run history", "error", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		slog.Error("Error writing run history", "error", err)
+		return
+	}
+
+	if err := trimHistory(constants.HistoryMaxRuns); err != nil {
+		slog.Error("Error trimming run history", "error", err)
+	}
+}
+
+// trimHistory drops all but the newest max runs from the history file.
+func trimHistory(max int) error {
+	data, err := os.ReadFile(historyFilePath())
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= max {
+		return nil
+	}
+
+	// Replace the file so that a crash leaves the old or the new history
+	f, err := os.CreateTemp(filepath.Dir(historyFilePath()), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bytes.Join(lines[len(lines)-max:], nil)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), historyFilePath())
+}
+
+// History returns recorded runs matching filter, newest first.
+func History(filter HistoryFilter) ([]RunState, error) {
+	var runs []RunState
+
+	f, err := os.Open(historyFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return runs, nil
+	} else if err != nil {
+		return runs, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var state RunState
+		if err := json.Unmarshal(scanner.Bytes(), &state); err != nil {
+			slog.Warn("Skipping malformed run history entry", "error", err)
+			continue
+		}
+
+		if !filter.Since.IsZero() && state.StartedAt.Before(filter.Since) {
+			continue
+		}
+		if filter.Failed && state.Status != StatusFailed {
+			continue
+		}
+		runs = append(runs, state)
+	}
+
+	slices.Reverse(runs)
+	return runs, scanner.Err()
+}
+
+// LastSuccess is when a whole run and each dir last succeeded according to
+// the run history, the zero time if never.
+type LastSuccess struct {
+	Run  time.Time
+	Dirs map[string]time.Time
+}
+
+// lastSuccess returns the last successes in runs, which are newest first.
+func lastSuccess(runs []RunState) LastSuccess {
+	last := LastSuccess{Dirs: map[string]time.Time{}}
+	for _, run := range runs {
+		if run.FinishedAt == nil {
+			continue
+		}
+
+		if last.Run.IsZero() && run.Status == StatusSucceeded {
+			last.Run = *run.FinishedAt
+		}
+		for _, r := range run.Dirs {
+			if _, ok := last.Dirs[r.Dir]; !ok && r.Err == nil {
+				last.Dirs[r.Dir] = *run.FinishedAt
+			}
+		}
+	}
+	return last
+}
+
+// readLastSuccess returns the last successes from the run history.
+func readLastSuccess() LastSuccess {
+	runs, err := History(HistoryFilter{})
+	if err != nil {
+		slog.Warn("Error reading run history", "error", err)
+	}
+	return lastSuccess(runs)
+}
@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLastSuccess(t *testing.T) {
+	at := func(h int) *time.Time {
+		v := time.Date(2025, time.January, 1, h, 0, 0, 0, time.UTC)
+		return &v
+	}
+	denied := errors.New("denied")
+
+	// Newest first, like History returns them
+	runs := []RunState{
+		{Status: StatusRunning, Dirs: []DirResult{{Dir: "/a"}}},
+		{Status: StatusFailed, FinishedAt: at(4), Dirs: []DirResult{{Dir: "/a", Err: denied}, {Dir: "/b"}}},
+		{Status: StatusSucceeded, FinishedAt: at(3), Dirs: []DirResult{{Dir: "/a"}, {Dir: "/b"}}},
+		{Status: StatusSucceeded, FinishedAt: at(2), Dirs: []DirResult{{Dir: "/c"}}},
+		{Status: StatusFailed, FinishedAt: at(1), Dirs: []DirResult{{Dir: "/d", Err: denied}}},
+	}
+
+	tests := []struct {
+		name    string
+		runs    []RunState
+		wantRun *time.Time
+		want    map[string]*time.Time
+	}{
+		{"no history", nil, nil, map[string]*time.Time{"/a": nil}},
+		{"history", runs, at(3), map[string]*time.Time{"/a": at(3), "/b": at(4), "/c": at(2), "/d": nil}},
+		{"only failures", runs[4:], nil, map[string]*time.Time{"/d": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last := lastSuccess(tt.runs)
+
+			want := func(v *time.Time) time.Time {
+				if v == nil {
+					return time.Time{}
+				}
+				return *v
+			}
+			if !last.Run.Equal(want(tt.wantRun)) {
+				t.Errorf("run = %v, want %v", last.Run, want(tt.wantRun))
+			}
+			for dir, v := range tt.want {
+				if got := last.Dirs[dir]; !got.Equal(want(v)) {
+					t.Errorf("dir %s = %v, want %v", dir, got, want(v))
+				}
+			}
+		})
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	setConfigRootDir(t, t.TempDir())
+
+	for i := range 5 {
+		appendHistory(RunState{ID: fmt.Sprint(i), Status: StatusSucceeded})
+	}
+
+	tests := []struct {
+		name string
+		max  int
+		want []string
+	}{
+		{"below max", 10, []string{"4", "3", "2", "1", "0"}},
+		{"at max", 5, []string{"4", "3", "2", "1", "0"}},
+		{"above max", 2, []string{"4", "3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := trimHistory(tt.max); err != nil {
+				t.Fatalf("trimHistory() error = %v", err)
+			}
+
+			runs, err := History(HistoryFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range runs {
+				got = append(got, r.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("History() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+var ErrNoIndex = errors.New("no local file index")
+
+// The manifests of encrypted snapshots leave out their files, so these are
+// kept in a local index for the anomaly check to compare runs with.
+
+func indexFilePath(key string) string {
+	return filepath.Join(config.BC.ConfigRootDir, constants.IndexDirName, key+".json")
+}
+
+// writeIndex records the files of the snapshot key in the local index.
+func writeIndex(key string, files []FileEntry) error {
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+
+	name := indexFilePath(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0600)
+}
+
+// readIndex returns the files of the snapshot key from the local index.
+func readIndex(key string) ([]FileEntry, error) {
+	data, err := os.ReadFile(indexFilePath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoIndex, key)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var files []FileEntry
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// removeIndex deletes the local index of the purged snapshot key.
+func removeIndex(key string) {
+	if err := os.Remove(indexFilePath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Error removing local file index", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+var ErrInterrupted = errors.New("backup interrupted")
+
+// Interrupted describes a run that was stopped before all dirs were backed
+// up, so that the next run can continue into the same snapshot. Done & Files
+// hold the results and files of the dirs already in the snapshot.
+type Interrupted struct {
+	RunID         string      `json:"run_id"`
+	Trigger       string      `json:"trigger"`
+	Prefix        string      `json:"prefix"`
+	Dirs          []string    `json:"dirs"`
+	Done          []DirResult `json:"done,omitempty"`
+	Files         []FileEntry `json:"files,omitempty"`
+	Purge         bool        `json:"purge"`
+	InterruptedAt time.Time   `json:"interrupted_at"`
+}
+
+func interruptedFilePath() string {
+	return filepath.Join(config.BC.ConfigRootDir, constants.InterruptedFileName)
+}
+
+// LoadInterrupted returns the interrupted run waiting to be resumed, or nil
+// if there is none.
+func LoadInterrupted() (*Interrupted, error) {
+	data, err := os.ReadFile(interruptedFilePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var i Interrupted
+	if err := json.Unmarshal(data, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func saveInterrupted(i Interrupted) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		slog.Error("Error encoding interrupted state", "error", err)
+		return
+	}
+
+	if err := os.WriteFile(interruptedFilePath(), data, 0600); err != nil {
+		slog.Error("Error saving interrupted state", "error", err)
+		return
+	}
+	slog.Warn("Saved interrupted state", "prefix", i.Prefix, "dirs", i.Dirs)
+}
+
+func clearInterrupted() {
+	if err := os.Remove(interruptedFilePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Error removing interrupted state", "error", err)
+	}
+}
@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+var (
+	ErrLocked   = errors.New("backups are locked by another process")
+	ErrLockLost = errors.New("lock was taken over by another process")
+)
+
+// remoteLock is the content of the lock object stored in the host's prefix.
+type remoteLock struct {
+	ID         string    `json:"id"`
+	Hostname   string    `json:"hostname"`
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// heldAt reports whether the lock is held at now, i.e. it exists and hasn't
+// expired. Expired locks are stale and may be taken over.
+func (l *remoteLock) heldAt(now time.Time) bool {
+	return l != nil && now.Before(l.ExpiresAt)
+}
+
+// is reports whether l is the lock other, i.e. it wasn't taken over.
+func (l *remoteLock) is(other *remoteLock) bool {
+	return l != nil && other != nil && l.ID == other.ID
+}
+
+func lockFilePath() string {
+	return filepath.Join(config.BC.ConfigRootDir, constants.LockFileName)
+}
+
+func lockKey(s3 *commonS3.S3) string {
+	return s3.Prefix + constants.LockFileName
+}
+
+// Lock takes the host-level file lock and the remote lock object in the
+// bucket so that only one process writes to the host's prefix at a time.
+// The returned context is canceled with ErrLockLost if the remote lock is
+// taken over while held, the returned func releases both locks.
+//
+// S3 has no compare-and-swap, so the remote lock is best-effort: hosts
+// acquiring it at the same moment may both succeed. It guards against
+// overlapping schedules and stale runs, not against racing writers.
+func Lock(ctx context.Context) (context.Context, func(), error) {
+	f, err := lockFile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s3, err := newS3(false)
+	if err != nil {
+		unlockFile(f)
+		return nil, nil, err
+	}
+
+	lock, err := acquireRemoteLock(s3)
+	if err != nil {
+		unlockFile(f)
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		refreshRemoteLock(s3, lock, done, cancel)
+	}()
+
+	return ctx, func() {
+		close(done)
+		wg.Wait()
+		cancel(nil)
+		releaseRemoteLock(s3, lock)
+		unlockFile(f)
+	}, nil
+}
+
+func lockFile() (*os.File, error) {
+	f, err := os.OpenFile(lockFilePath(), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, lockFilePath())
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func unlockFile(f *os.File) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		slog.Error("Error releasing lock file", "error", err)
+	}
+	f.Close()
+}
+
+func readRemoteLock(s3 *commonS3.S3) (*remoteLock, error) {
+	out, err := awsS3.New(s3.Sess).GetObject(&awsS3.GetObjectInput{
+		Bucket: aws.String(s3.Bucket),
+		Key:    aws.String(lockKey(s3)),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == awsS3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var lock remoteLock
+	if err := json.NewDecoder(out.Body).Decode(&lock); err != nil {
+		slog.Warn("Ignoring malformed lock object", "key", lockKey(s3), "error", err)
+		return nil, nil
+	}
+	return &lock, nil
+}
+
+func writeRemoteLock(s3 *commonS3.S3, lock *remoteLock) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	_, err = awsS3.New(s3.Sess).PutObject(&awsS3.PutObjectInput{
+		Bucket:      aws.String(s3.Bucket),
+		Key:         aws.String(lockKey(s3)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func acquireRemoteLock(s3 *commonS3.S3) (*remoteLock, error) {
+	existing, err := readRemoteLock(s3)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing.heldAt(time.Now()) {
+		return nil, fmt.Errorf("%w: held by %s (pid %d) until %s", ErrLocked, existing.Hostname, existing.PID, existing.ExpiresAt.Format(time.RFC3339))
+	}
+	if existing != nil {
+		slog.Warn("Taking over stale lock", "hostname", existing.Hostname, "pid", existing.PID, "expiredAt", existing.ExpiresAt)
+	}
+
+	now := time.Now()
+	lock := &remoteLock{
+		ID:         uuid.NewString(),
+		Hostname:   config.Get().Backup.Hostname,
+		PID:        os.Getpid(),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(config.Get().Backup.LockTTL),
+	}
+	if err := writeRemoteLock(s3, lock); err != nil {
+		return nil, err
+	}
+
+	// S3 has no compare-and-swap, read back to detect a concurrent writer.
+	written, err := readRemoteLock(s3)
+	if err != nil {
+		return nil, err
+	}
+	if !written.is(lock) {
+		return nil, fmt.Errorf("%w: lost race for lock object", ErrLocked)
+	}
+
+	slog.Debug("Acquired lock", "key", lockKey(s3), "expiresAt", lock.ExpiresAt)
+	return lock, nil
+}
+
+// refreshRemoteLock extends the lock expiry until done is closed so that
+// long running backups don't lose their lock. If another process took the
+// lock over in the meantime, it isn't claimed back, the run is canceled
+// with ErrLockLost instead.
+func refreshRemoteLock(s3 *commonS3.S3, lock *remoteLock, done <-chan struct{}, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(config.Get().Backup.LockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			existing, err := readRemoteLock(s3)
+			if err != nil {
+				slog.Error("Error reading lock", "error", err)
+				continue
+			}
+			if !existing.is(lock) {
+				slog.Error("Lock was taken over by another process, stopping", "key", lockKey(s3))
+				cancel(ErrLockLost)
+				return
+			}
+
+			lock.ExpiresAt = time.Now().Add(config.Get().Backup.LockTTL)
+			if err := writeRemoteLock(s3, lock); err != nil {
+				slog.Error("Error refreshing lock", "error", err)
+			}
+		}
+	}
+}
+
+func releaseRemoteLock(s3 *commonS3.S3, lock *remoteLock) {
+	existing, err := readRemoteLock(s3)
+	if err != nil {
+		slog.Error("Error reading lock", "error", err)
+		return
+	}
+	if !existing.is(lock) {
+		slog.Warn("Lock was taken over by another process", "key", lockKey(s3))
+		return
+	}
+
+	// Every refresh writes a new version, in versioned buckets a plain delete
+	// would leave them all behind a delete marker.
+	reason, err := deleteSnapshotVersions(s3, lockKey(s3))
+	if err != nil {
+		slog.Error("Error releasing lock", "error", err)
+		return
+	}
+	if reason == "" {
+		return
+	}
+
+	// Versions under retention can't be deleted, a delete marker still
+	// releases the lock. Expire them with a lifecycle rule on the lock key.
+	slog.Warn("Lock versions are kept until their retention lapses, add a lifecycle rule expiring noncurrent versions of the lock key", "key", lockKey(s3), "reason", reason)
+	if _, err := awsS3.New(s3.Sess).DeleteObject(&awsS3.DeleteObjectInput{
+		Bucket: aws.String(s3.Bucket),
+		Key:    aws.String(lockKey(s3)),
+	}); err != nil {
+		slog.Error("Error releasing lock", "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
+)
+
+func TestRemoteLockHeldAt(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		lock *remoteLock
+		want bool
+	}{
+		{"no lock", nil, false},
+		{"not expired", &remoteLock{ExpiresAt: now.Add(time.Minute)}, true},
+		{"expires now", &remoteLock{ExpiresAt: now}, false},
+		{"expired", &remoteLock{ExpiresAt: now.Add(-time.Minute)}, false},
+		{"zero expiry", &remoteLock{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lock.heldAt(now); got != tt.want {
+				t.Errorf("heldAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteLockIs(t *testing.T) {
+	ours := &remoteLock{ID: "a", Hostname: "vm"}
+
+	tests := []struct {
+		name     string
+		existing *remoteLock
+		want     bool
+	}{
+		{"same lock", &remoteLock{ID: "a", Hostname: "vm", ExpiresAt: time.Now()}, true},
+		{"taken over", &remoteLock{ID: "b", Hostname: "vm"}, false},
+		{"deleted", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.existing.is(ours); got != tt.want {
+				t.Errorf("is() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseRemoteLock(t *testing.T) {
+	lock := &remoteLock{ID: "a", Hostname: "vm", ExpiresAt: time.Now().Add(time.Minute)}
+
+	tests := []struct {
+		name      string
+		legalHold bool
+		want      []string
+	}{
+		{"deletes every version", false, []string{"POST /b?delete= v1 v2 m1"}},
+		{"locked version", true, []string{"DELETE /b/p/.gos3backup.lock"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deletes []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Query().Has("versions"):
+					fmt.Fprint(w, `<ListVersionsResult>
+<Version><Key>p/.gos3backup.lock</Key><VersionId>v1</VersionId></Version>
+<Version><Key>p/.gos3backup.lock</Key><VersionId>v2</VersionId></Version>
+<DeleteMarker><Key>p/.gos3backup.lock</Key><VersionId>m1</VersionId></DeleteMarker>
+</ListVersionsResult>`)
+				case r.Method == http.MethodGet:
+					_ = json.NewEncoder(w).Encode(lock)
+				case r.Method == http.MethodHead:
+					if tt.legalHold {
+						w.Header().Set("x-amz-object-lock-legal-hold", "ON")
+					}
+				case r.Method == http.MethodPost:
+					body, _ := io.ReadAll(r.Body)
+					var ids []string
+					for _, part := range strings.Split(string(body), "<VersionId>")[1:] {
+						ids = append(ids, part[:strings.Index(part, "<")])
+					}
+					mu.Lock()
+					deletes = append(deletes, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+" "+strings.Join(ids, " "))
+					mu.Unlock()
+					fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+				case r.Method == http.MethodDelete:
+					mu.Lock()
+					deletes = append(deletes, r.Method+" "+r.URL.Path)
+					mu.Unlock()
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer srv.Close()
+
+			sess, err := session.NewSession(&aws.Config{
+				Region:           aws.String("us-east-1"),
+				Endpoint:         aws.String(srv.URL),
+				Credentials:      credentials.NewStaticCredentials("AK", "SK", ""),
+				S3ForcePathStyle: aws.Bool(true),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			releaseRemoteLock(&commonS3.S3{Sess: sess, Bucket: "b", Prefix: "p/"}, lock)
+
+			if got := strings.Join(deletes, ", "); got != strings.Join(tt.want, ", ") {
+				t.Errorf("deletes = %q, want %q", got, strings.Join(tt.want, ", "))
+			}
+		})
+	}
+}
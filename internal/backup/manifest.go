@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// FileEntry is a file in a snapshot. Path starts with the name of the backed
+// up dir.
+type FileEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Manifest is stored in a snapshot once its run finished and describes what
+// it contains. The manifest itself isn't encrypted, so the files of encrypted
+// snapshots are left out and kept in the local index instead.
+type Manifest struct {
+	RunID     string      `json:"run_id"`
+	Hostname  string      `json:"hostname"`
+	CreatedAt time.Time   `json:"created_at"`
+	Encrypted bool        `json:"encrypted"`
+	Dirs      []DirResult `json:"dirs"`
+	Files     []FileEntry `json:"files,omitempty"`
+}
+
+// filesOf returns the files backed up by results.
+func filesOf(results []DirResult) []FileEntry {
+	var files []FileEntry
+	for _, r := range results {
+		files = append(files, r.Files...)
+	}
+	return files
+}
+
+// writeManifest stores the manifest of the snapshot at the prefix of s3.
+func writeManifest(s3 *commonS3.S3, runID string, results []DirResult, files []FileEntry) error {
+	encrypted := config.Get().Backup.Encryption.Enabled
+	if encrypted {
+		if err := writeIndex(path.Base(s3.Prefix), files); err != nil {
+			slog.Error("Error writing local file index, the next run can't be checked for anomalies", "error", err)
+		}
+		files = nil
+	}
+
+	data, err := json.Marshal(Manifest{
+		RunID:     runID,
+		Hostname:  config.Get().Backup.Hostname,
+		CreatedAt: time.Now(),
+		Encrypted: encrypted,
+		Dirs:      results,
+		Files:     files,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = awsS3.New(s3.Sess).PutObject(newObjectLock().putObject(&awsS3.PutObjectInput{
+		Bucket:      aws.String(s3.Bucket),
+		Key:         aws.String(s3.Prefix + constants.ManifestFileName),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}))
+	return err
+}
@@ -0,0 +1,408 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// mounter serves the snapshots of every host in the bucket over FUSE.
+type mounter struct {
+	client *awsS3.S3
+	bucket string
+	prefix string
+	cache  *blockCache
+}
+
+// lazyDir is a directory whose children are only listed from S3 once it is
+// first looked into. Directories within a snapshot are populated up front
+// and have no load func.
+type lazyDir struct {
+	fs.Inode
+	mtime time.Time
+	load  func(ctx context.Context, n *lazyDir) error
+
+	mu     sync.Mutex
+	loaded bool
+}
+
+var (
+	_ fs.NodeLookuper  = (*lazyDir)(nil)
+	_ fs.NodeReaddirer = (*lazyDir)(nil)
+	_ fs.NodeGetattrer = (*lazyDir)(nil)
+)
+
+// populate loads the children of n unless that was done already. Failures
+// are retried on the next access.
+func (n *lazyDir) populate(ctx context.Context) syscall.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.loaded || n.load == nil {
+		return 0
+	}
+	if err := n.load(ctx, n); err != nil {
+		slog.Error("Error listing directory", "path", n.Path(nil), "error", err)
+		return syscall.EIO
+	}
+	n.loaded = true
+	return 0
+}
+
+func (n *lazyDir) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := n.populate(ctx); errno != 0 {
+		return nil, errno
+	}
+
+	child := n.GetChild(name)
+	if child == nil {
+		return nil, syscall.ENOENT
+	}
+
+	var attr fuse.AttrOut
+	if errno := child.Operations().(fs.NodeGetattrer).Getattr(ctx, nil, &attr); errno != 0 {
+		return nil, errno
+	}
+	out.Attr = attr.Attr
+	return child, 0
+}
+
+func (n *lazyDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	if errno := n.populate(ctx); errno != 0 {
+		return nil, errno
+	}
+
+	var entries []fuse.DirEntry
+	for name, child := range n.Children() {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: child.Mode(), Ino: child.StableAttr().Ino})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *lazyDir) Getattr(_ context.Context, _ fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0555
+	out.SetTimes(nil, &n.mtime, &n.mtime)
+	return 0
+}
+
+// fileNode is a read-only file of a snapshot. A reader is set up per open
+// file handle, e.g. compressed archive members are then extracted into the
+// cache, and closed on release.
+type fileNode struct {
+	fs.Inode
+	size  int64
+	mtime time.Time
+	open  func() (io.ReaderAt, error)
+}
+
+// fileHandle is an open fileNode.
+type fileHandle struct {
+	r io.ReaderAt
+}
+
+var (
+	_ fs.NodeOpener    = (*fileNode)(nil)
+	_ fs.NodeReader    = (*fileNode)(nil)
+	_ fs.NodeReleaser  = (*fileNode)(nil)
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+)
+
+func (n *fileNode) Getattr(_ context.Context, _ fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0444
+	out.Size = uint64(n.size)
+	out.SetTimes(nil, &n.mtime, &n.mtime)
+	return 0
+}
+
+func (n *fileNode) Open(_ context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	r, err := n.open()
+	if err != nil {
+		slog.Error("Error opening file", "path", n.Path(nil), "error", err)
+		return nil, 0, syscall.EIO
+	}
+	return &fileHandle{r: r}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *fileNode) Read(_ context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := fh.(*fileHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+
+	c, err := h.r.ReadAt(dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		slog.Error("Error reading file", "path", n.Path(nil), "error", err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:c]), 0
+}
+
+func (n *fileNode) Release(_ context.Context, fh fs.FileHandle) syscall.Errno {
+	h, ok := fh.(*fileHandle)
+	if !ok {
+		return syscall.EBADF
+	}
+
+	if c, ok := h.r.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Warn("Error closing file", "path", n.Path(nil), "error", err)
+		}
+	}
+	return 0
+}
+
+// subdirs returns the names of the common prefixes directly below prefix.
+func (m *mounter) subdirs(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := m.client.ListObjectsV2PagesWithContext(ctx, &awsS3.ListObjectsV2Input{
+		Bucket:    aws.String(m.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *awsS3.ListObjectsV2Output, _ bool) bool {
+		for _, p := range page.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(p.Prefix), prefix), "/"))
+		}
+		return true
+	})
+	return names, err
+}
+
+// loadHosts adds a directory per host with backups in the bucket.
+func (m *mounter) loadHosts(ctx context.Context, n *lazyDir) error {
+	hosts, err := m.subdirs(ctx, m.prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		prefix := m.prefix + host + "/"
+		dir := &lazyDir{mtime: n.mtime, load: func(ctx context.Context, n *lazyDir) error {
+			return m.loadSnapshots(ctx, n, prefix)
+		}}
+		n.AddChild(host, n.NewPersistentInode(ctx, dir, fs.StableAttr{Mode: fuse.S_IFDIR}), false)
+	}
+	return nil
+}
+
+// loadSnapshots adds a directory per snapshot of the host at prefix.
+func (m *mounter) loadSnapshots(ctx context.Context, n *lazyDir, prefix string) error {
+	keys, err := m.subdirs(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		t := snapshotTime(key)
+		if t.IsZero() {
+			continue
+		}
+
+		prefix := prefix + key + "/"
+		dir := &lazyDir{mtime: t, load: func(ctx context.Context, n *lazyDir) error {
+			return m.loadSnapshot(ctx, n, prefix)
+		}}
+		n.AddChild(key, n.NewPersistentInode(ctx, dir, fs.StableAttr{Mode: fuse.S_IFDIR}), false)
+	}
+	return nil
+}
+
+// loadSnapshot adds the files of the snapshot at prefix. Archives are
+// expanded from their central directory, mtimes are taken from the manifest
+// if there is one. Encrypted archives can't be read without the private key
+// and are shown as is.
+func (m *mounter) loadSnapshot(ctx context.Context, n *lazyDir, prefix string) error {
+	manifest, err := readManifest(m.client, m.bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	mtimes := map[string]time.Time{}
+	if manifest != nil {
+		for _, f := range manifest.Files {
+			mtimes[f.Path] = f.ModTime
+		}
+	}
+
+	var objects []*awsS3.Object
+	if err := m.client.ListObjectsV2PagesWithContext(ctx, &awsS3.ListObjectsV2Input{
+		Bucket: aws.String(m.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *awsS3.ListObjectsV2Output, _ bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	}); err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		name := strings.TrimPrefix(aws.StringValue(obj.Key), prefix)
+		if name == constants.ManifestFileName || name == constants.SuspiciousFileName || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		r := newCachedReader(&objectReader{client: m.client, bucket: m.bucket, key: aws.StringValue(obj.Key), size: aws.Int64Value(obj.Size)}, m.cache)
+		uploaded := aws.TimeValue(obj.LastModified)
+
+		if strings.HasSuffix(name, ".zip") && !strings.Contains(name, "/") {
+			members, err := zipMembers(r, r.obj.size, strings.TrimSuffix(name, ".zip"), uploaded)
+			if err == nil {
+				m.addArchive(ctx, n, r, members, mtimes)
+				continue
+			}
+			slog.Warn("Error reading archive, showing it as is", "key", r.obj.key, "error", err)
+		}
+
+		mtime, ok := mtimes[name]
+		if !ok {
+			mtime = uploaded
+		}
+		addFile(ctx, n, name, &fileNode{size: r.obj.size, mtime: mtime, open: func() (io.ReaderAt, error) {
+			return r, nil
+		}})
+	}
+	return nil
+}
+
+// addArchive adds the members of the archive read from r. Stored members
+// are read from the archive directly, compressed ones are extracted on open.
+func (m *mounter) addArchive(ctx context.Context, n *lazyDir, r *cachedReader, members []zipMember, mtimes map[string]time.Time) {
+	for _, member := range members {
+		f := member.file
+		mtime, ok := mtimes[member.Path]
+		if !ok {
+			mtime = member.ModTime
+		}
+
+		addFile(ctx, n, member.Path, &fileNode{size: member.Size, mtime: mtime, open: func() (io.ReaderAt, error) {
+			if f.Method == zip.Store {
+				off, err := f.DataOffset()
+				if err != nil {
+					return nil, err
+				}
+				return io.NewSectionReader(r, off, member.Size), nil
+			}
+			return m.cache.extract(r.id, f)
+		}})
+	}
+}
+
+// addFile adds file at the slash separated path p below n, creating the
+// directories in between.
+func addFile(ctx context.Context, n *lazyDir, p string, file *fileNode) {
+	parent := &n.Inode
+	dirs, name := path.Split(path.Clean(p))
+	for _, d := range strings.Split(strings.Trim(dirs, "/"), "/") {
+		if d == "" || d == "." || d == ".." {
+			continue
+		}
+
+		child := parent.GetChild(d)
+		if child == nil {
+			child = parent.NewPersistentInode(ctx, &lazyDir{mtime: n.mtime}, fs.StableAttr{Mode: fuse.S_IFDIR})
+			parent.AddChild(d, child, false)
+		}
+		parent = child
+	}
+
+	parent.AddChild(name, parent.NewPersistentInode(ctx, file, fs.StableAttr{Mode: fuse.S_IFREG}), false)
+}
+
+// Mount exposes every snapshot in the bucket read-only at mountpoint as
+// /<hostname>/<datetime>/<dir>/..., until ctx is canceled. Files are read
+// lazily with range requests and kept in cacheDir up to cacheSize bytes, or
+// in a temporary dir removed on unmount if cacheDir is empty.
+func Mount(ctx context.Context, mountpoint, cacheDir string, cacheSize int64) error {
+	sess, err := newSession(config.Get().S3)
+	if err != nil {
+		return err
+	}
+
+	if cacheDir == "" {
+		cacheDir, err = os.MkdirTemp("", constants.MountFsName+"-cache-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(cacheDir)
+	}
+
+	cache, err := newBlockCache(cacheDir, cacheSize)
+	if err != nil {
+		return err
+	}
+
+	m := &mounter{
+		client: awsS3.New(sess),
+		bucket: config.Get().S3.Bucket,
+		cache:  cache,
+	}
+	if p := strings.Trim(config.Get().S3.Prefix, "/"); p != "" {
+		m.prefix = p + "/"
+	}
+
+	timeout := constants.MountAttrTimeout
+	root := &lazyDir{mtime: time.Now(), load: m.loadHosts}
+	server, err := fs.Mount(mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      constants.MountFsName,
+			Name:        constants.MountFsName,
+			Options:     []string{"ro"},
+			DirectMount: true,
+		},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Mounted backups", "mountpoint", mountpoint, "cache", cacheDir)
+
+	// Unmounting fails while files are in use, keep trying until it is
+	// unmounted by us or externally
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		for {
+			err := server.Unmount()
+			if err == nil {
+				return
+			}
+			slog.Warn("Error unmounting, retrying", "mountpoint", mountpoint, "error", err)
+
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	server.Wait()
+	close(done)
+	slog.Info("Unmounted backups", "mountpoint", mountpoint)
+	return nil
+}
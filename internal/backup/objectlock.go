@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
+	"github.com/hibare/GoS3Backup/internal/config"
+)
+
+var ErrObjectLockDisabled = errors.New("object lock is not enabled on the bucket")
+
+// objectLock is the Object Lock retention & legal hold set on uploads. All
+// fields are nil when Object Lock is disabled.
+type objectLock struct {
+	mode      *string
+	until     *time.Time
+	legalHold *string
+}
+
+// lockUntil returns when objects uploaded at now are purged under the
+// retention policy, i.e. at the retention.count-th next scheduled run.
+func lockUntil(now time.Time) (time.Time, error) {
+	schedule, err := cronSchedule()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	until := now
+	for range config.Get().Backup.Retention.Count {
+		until = schedule.Next(until)
+	}
+	return until, nil
+}
+
+// newObjectLock returns the Object Lock settings for objects uploaded now.
+func newObjectLock() objectLock {
+	c := config.Get().Backup.ObjectLock
+	if !c.Enabled {
+		return objectLock{}
+	}
+
+	var l objectLock
+	if c.LegalHold {
+		l.legalHold = aws.String(awsS3.ObjectLockLegalHoldStatusOn)
+	}
+
+	until, err := lockUntil(time.Now())
+	if err != nil {
+		slog.Error("Error computing object lock retention, uploading without", "cron", config.Get().Backup.Cron, "error", err)
+		return l
+	}
+	if until.After(time.Now()) {
+		l.mode = aws.String(strings.ToUpper(c.Mode))
+		l.until = aws.Time(until)
+	}
+	return l
+}
+
+func (l objectLock) putObject(in *awsS3.PutObjectInput) *awsS3.PutObjectInput {
+	in.ObjectLockMode = l.mode
+	in.ObjectLockRetainUntilDate = l.until
+	in.ObjectLockLegalHoldStatus = l.legalHold
+	return in
+}
+
+func (l objectLock) upload(in *s3manager.UploadInput) *s3manager.UploadInput {
+	in.ObjectLockMode = l.mode
+	in.ObjectLockRetainUntilDate = l.until
+	in.ObjectLockLegalHoldStatus = l.legalHold
+	return in
+}
+
+// lockedVersion returns a description of why a version of an object can't
+// be deleted yet, or "" if it can.
+func lockedVersion(client *awsS3.S3, bucket string, v *awsS3.ObjectVersion) (string, error) {
+	out, err := client.HeadObject(&awsS3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       v.Key,
+		VersionId: v.VersionId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if aws.StringValue(out.ObjectLockLegalHoldStatus) == awsS3.ObjectLockLegalHoldStatusOn {
+		return "legal hold", nil
+	}
+	if until := aws.TimeValue(out.ObjectLockRetainUntilDate); until.After(time.Now()) {
+		return fmt.Sprintf("%s retention until %s", strings.ToLower(aws.StringValue(out.ObjectLockMode)), until.Local().Format(time.DateTime)), nil
+	}
+	return "", nil
+}
+
+// deleteSnapshotVersions deletes every version of the objects at prefix, as
+// deleting objects in a bucket with Object Lock only adds delete markers.
+// If any version is still locked nothing is deleted and the reason is
+// returned, so that the snapshot is kept whole until it can be purged.
+func deleteSnapshotVersions(s3 *commonS3.S3, prefix string) (string, error) {
+	client := awsS3.New(s3.Sess)
+
+	var versions []*awsS3.ObjectVersion
+	var ids []*awsS3.ObjectIdentifier
+	if err := client.ListObjectVersionsPages(&awsS3.ListObjectVersionsInput{
+		Bucket: aws.String(s3.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *awsS3.ListObjectVersionsOutput, _ bool) bool {
+		versions = append(versions, page.Versions...)
+		for _, v := range page.Versions {
+			ids = append(ids, &awsS3.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, m := range page.DeleteMarkers {
+			ids = append(ids, &awsS3.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+		return true
+	}); err != nil {
+		return "", err
+	}
+
+	for _, v := range versions {
+		reason, err := lockedVersion(client, s3.Bucket, v)
+		if err != nil {
+			return "", fmt.Errorf("checking lock of %s: %w", aws.StringValue(v.Key), err)
+		}
+		if reason != "" {
+			return fmt.Sprintf("%s is under %s", aws.StringValue(v.Key), reason), nil
+		}
+	}
+
+	// DeleteObjects takes at most 1000 keys per request
+	for start := 0; start < len(ids); start += 1000 {
+		out, err := client.DeleteObjects(&awsS3.DeleteObjectsInput{
+			Bucket: aws.String(s3.Bucket),
+			Delete: &awsS3.Delete{Objects: ids[start:min(start+1000, len(ids))], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return "", fmt.Errorf("deleting %s: %s: %s", aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message))
+		}
+	}
+	return "", nil
+}
+
+// CheckObjectLock checks that the bucket has Object Lock enabled, without
+// which uploads with retention are rejected.
+func CheckObjectLock() error {
+	s3, err := newS3(false)
+	if err != nil {
+		return err
+	}
+
+	out, err := awsS3.New(s3.Sess).GetObjectLockConfiguration(&awsS3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s3.Bucket),
+	})
+	if err != nil {
+		return err
+	}
+	if out.ObjectLockConfiguration == nil || aws.StringValue(out.ObjectLockConfiguration.ObjectLockEnabled) != awsS3.ObjectLockEnabledEnabled {
+		return ErrObjectLockDisabled
+	}
+	return nil
+}
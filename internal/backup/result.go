@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/notifiers"
+)
+
+// DirResult is the outcome of backing up a single directory.
+type DirResult struct {
+	Dir          string
+	Key          string
+	TotalDirs    int
+	TotalFiles   int
+	SuccessFiles int
+	Size         int64
+	Duration     time.Duration
+	Err          error
+
+	// Files lists what was backed up for the snapshot manifest, it isn't
+	// kept in the run history.
+	Files []FileEntry `json:"-"`
+}
+
+type dirResultJSON struct {
+	Dir          string `json:"dir"`
+	Key          string `json:"key,omitempty"`
+	TotalDirs    int    `json:"total_dirs"`
+	TotalFiles   int    `json:"total_files"`
+	SuccessFiles int    `json:"success_files"`
+	Size         int64  `json:"size"`
+	Duration     string `json:"duration"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (r DirResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dirResultJSON{
+		Dir:          r.Dir,
+		Key:          r.Key,
+		TotalDirs:    r.TotalDirs,
+		TotalFiles:   r.TotalFiles,
+		SuccessFiles: r.SuccessFiles,
+		Size:         r.Size,
+		Duration:     r.Duration.String(),
+		Error:        errString(r.Err),
+	})
+}
+
+func (r *DirResult) UnmarshalJSON(data []byte) error {
+	var j dirResultJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	duration, _ := time.ParseDuration(j.Duration)
+	*r = DirResult{
+		Dir:          j.Dir,
+		Key:          j.Key,
+		TotalDirs:    j.TotalDirs,
+		TotalFiles:   j.TotalFiles,
+		SuccessFiles: j.SuccessFiles,
+		Size:         j.Size,
+		Duration:     duration,
+		Err:          stringErr(j.Error),
+	}
+	return nil
+}
+
+// event returns the notifier event of r, last is when its dir was last
+// backed up successfully.
+func (r DirResult) event(last time.Time) notifiers.Event {
+	e := notifiers.Event{
+		Directory:    r.Dir,
+		Key:          r.Key,
+		TotalDirs:    r.TotalDirs,
+		TotalFiles:   r.TotalFiles,
+		SuccessFiles: r.SuccessFiles,
+		Size:         r.Size,
+		Duration:     r.Duration,
+		LastSuccess:  last,
+	}
+	if r.Err != nil {
+		e.Error = r.Err.Error()
+	}
+	return e
+}
+
+// PurgeResult is the outcome of purging backups exceeding the retention count.
+type PurgeResult struct {
+	Deleted   []string
+	Failed    []string
+	Locked    []string
+	Remaining int
+	Expected  int
+	Err       error
+}
+
+type purgeResultJSON struct {
+	Deleted   []string `json:"deleted"`
+	Failed    []string `json:"failed,omitempty"`
+	Locked    []string `json:"locked,omitempty"`
+	Remaining int      `json:"remaining"`
+	Expected  int      `json:"expected"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func (r PurgeResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(purgeResultJSON{
+		Deleted:   r.Deleted,
+		Failed:    r.Failed,
+		Locked:    r.Locked,
+		Remaining: r.Remaining,
+		Expected:  r.Expected,
+		Error:     errString(r.Err),
+	})
+}
+
+func (r *PurgeResult) UnmarshalJSON(data []byte) error {
+	var j purgeResultJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	*r = PurgeResult{
+		Deleted:   j.Deleted,
+		Failed:    j.Failed,
+		Locked:    j.Locked,
+		Remaining: j.Remaining,
+		Expected:  j.Expected,
+		Err:       stringErr(j.Error),
+	}
+	return nil
+}
+
+func (r PurgeResult) purge() notifiers.Purge {
+	p := notifiers.Purge{
+		Deleted:   r.Deleted,
+		Failed:    r.Failed,
+		Locked:    r.Locked,
+		Remaining: r.Remaining,
+		Retention: config.Get().Backup.Retention.Count,
+		Expected:  r.Expected,
+	}
+	if r.Err != nil {
+		p.Error = r.Err.Error()
+	}
+	return p
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func stringErr(s string) error {
+	if s == "" {
+		return nil
+	}
+	return errors.New(s)
+}
@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"log/slog"
+	"time"
+
+	commonConstants "github.com/hibare/GoCommon/v2/pkg/constants"
+	"github.com/hibare/GoS3Backup/internal/config"
+)
+
+// expectedBackups returns the number of backups that should exist given the
+// retention count and the number of scheduled runs since the oldest backup.
+// keys must be sorted in descending order.
+func expectedBackups(keys []string) int {
+	if len(keys) == 0 {
+		return 0
+	}
+
+	oldest, err := time.ParseInLocation(commonConstants.DefaultDateTimeLayout, keys[len(keys)-1], time.Local)
+	if err != nil {
+		slog.Warn("Error parsing backup datetime", "key", keys[len(keys)-1], "error", err)
+		return 0
+	}
+
+	schedule, err := cronSchedule()
+	if err != nil {
+		slog.Warn("Error parsing cron", "cron", config.Get().Backup.Cron, "error", err)
+		return 0
+	}
+
+	// The oldest backup accounts for one run, count the runs scheduled since.
+	expected := 1
+	now := time.Now()
+	for next := schedule.Next(oldest); !next.After(now) && expected < config.Get().Backup.Retention.Count; next = schedule.Next(next) {
+		expected++
+	}
+
+	return min(expected, config.Get().Backup.Retention.Count)
+}
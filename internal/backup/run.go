@@ -0,0 +1,413 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/heartbeat"
+	"github.com/hibare/GoS3Backup/internal/metrics"
+	"github.com/hibare/GoS3Backup/internal/notifiers"
+)
+
+// Run triggers.
+const (
+	TriggerCron    = "cron"
+	TriggerManual  = "manual"
+	TriggerAPI     = "api"
+	TriggerResume  = "resume"
+	TriggerStartup = "startup"
+	TriggerCatchUp = "catch-up"
+)
+
+// Run statuses.
+const (
+	StatusRunning     = "running"
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+	StatusSkipped     = "skipped"
+)
+
+// maxTrackedRuns is the number of finished runs kept in memory for GetRun.
+const maxTrackedRuns = 50
+
+var (
+	ErrRunInProgress = errors.New("a backup run is already in progress")
+	ErrRunNotFound   = errors.New("run not found")
+)
+
+// RunState describes a backup run and its progress.
+type RunState struct {
+	ID          string       `json:"id"`
+	Trigger     string       `json:"trigger"`
+	Status      string       `json:"status"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	TotalDirs   int          `json:"total_dirs"`
+	DoneDirs    int          `json:"done_dirs"`
+	CurrentDir  string       `json:"current_dir,omitempty"`
+	Dirs        []DirResult  `json:"dirs"`
+	Purge       *PurgeResult `json:"purge,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	ResumedFrom string       `json:"resumed_from,omitempty"`
+	Anomaly     *Anomaly     `json:"anomaly,omitempty"`
+}
+
+var (
+	// runMu is held for the whole duration of a run.
+	runMu sync.Mutex
+
+	// stateMu guards current and runs.
+	stateMu  sync.RWMutex
+	current  *RunState
+	runs     = map[string]*RunState{}
+	runOrder []string
+)
+
+// Run performs a backup of all configured dirs, optionally followed by a
+// purge of old backups, sends the run summary notification, pings the
+// heartbeat monitor and records the run in the history. An interrupted run
+// is continued first. It returns ErrRunInProgress if another run is active.
+func Run(ctx context.Context, trigger string, purge bool) (RunState, error) {
+	if !runMu.TryLock() {
+		return RunState{}, ErrRunInProgress
+	}
+	defer runMu.Unlock()
+
+	state := newRun(trigger)
+	execute(ctx, state, purge)
+
+	return GetRun(state.ID)
+}
+
+// Start begins a run with purge in the background and returns its initial
+// state. It returns ErrRunInProgress if another run is active.
+func Start(ctx context.Context, trigger string) (RunState, error) {
+	if !runMu.TryLock() {
+		return RunState{}, ErrRunInProgress
+	}
+
+	state := newRun(trigger)
+	snapshot, _ := GetRun(state.ID)
+
+	go func() {
+		defer runMu.Unlock()
+		execute(ctx, state, true)
+	}()
+
+	return snapshot, nil
+}
+
+// Resume continues an interrupted run, if there is one.
+func Resume(ctx context.Context) error {
+	pending, err := LoadInterrupted()
+	if err != nil || pending == nil {
+		return err
+	}
+
+	_, err = Run(ctx, TriggerResume, pending.Purge)
+	return err
+}
+
+// Wait blocks until the active run, if any, has finished.
+func Wait() {
+	runMu.Lock()
+	defer runMu.Unlock()
+}
+
+// Exclusive calls fn once no run is active, keeping runs from starting
+// until it returns.
+func Exclusive(fn func()) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	fn()
+}
+
+// GetRun returns the state of a current or recently finished run.
+func GetRun(id string) (RunState, error) {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+
+	state, ok := runs[id]
+	if !ok {
+		return RunState{}, ErrRunNotFound
+	}
+
+	s := *state
+	s.Dirs = append([]DirResult(nil), state.Dirs...)
+	return s, nil
+}
+
+func newRun(trigger string) *RunState {
+	state := &RunState{
+		ID:        uuid.NewString(),
+		Trigger:   trigger,
+		Status:    StatusRunning,
+		StartedAt: time.Now(),
+		TotalDirs: len(config.Get().Backup.Dirs),
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	current = state
+	runs[state.ID] = state
+	runOrder = append(runOrder, state.ID)
+	if len(runOrder) > maxTrackedRuns {
+		delete(runs, runOrder[0])
+		runOrder = runOrder[1:]
+	}
+
+	return state
+}
+
+func execute(ctx context.Context, state *RunState, withPurge bool) {
+	slog.Info("Starting backup run", "id", state.ID, "trigger", state.Trigger)
+
+	var results []DirResult
+	var purge *PurgeResult
+	last := readLastSuccess()
+	lockCtx, release, err := Lock(ctx)
+	if err == nil {
+		heartbeat.Start()
+		results, purge, err = backupAndPurge(lockCtx, state, withPurge, last)
+		if errors.Is(context.Cause(lockCtx), ErrLockLost) {
+			err = errors.Join(ErrLockLost, err)
+		}
+		release()
+	}
+
+	// Losing the lock is a failure, the interrupted dirs are still resumed
+	// by the next run
+	duration := time.Since(state.StartedAt)
+	interrupted := errors.Is(err, ErrInterrupted) && !errors.Is(err, ErrLockLost)
+	skipped := errors.Is(err, ErrLocked)
+	if !interrupted && !skipped {
+		notifySummary(results, purge, duration, err, last)
+	}
+
+	if err == nil {
+		err = runError(results, purge)
+	}
+
+	finished := time.Now()
+	stateMu.Lock()
+	state.FinishedAt = &finished
+	state.CurrentDir = ""
+	state.Purge = purge
+	switch {
+	case interrupted:
+		state.Status = StatusInterrupted
+		state.Error = err.Error()
+	case skipped:
+		state.Status = StatusSkipped
+		state.Error = err.Error()
+	case err != nil:
+		state.Status = StatusFailed
+		state.Error = err.Error()
+	default:
+		state.Status = StatusSucceeded
+	}
+	current = nil
+	record := *state
+	stateMu.Unlock()
+
+	appendHistory(record)
+
+	// An interrupted run is neither a success nor a failure, it is
+	// continued by the next run. A run skipped because another process
+	// holds the lock leaves the heartbeat to that process.
+	if interrupted {
+		slog.Warn("Backup run interrupted", "id", state.ID, "duration", duration)
+		metrics.RunsTotal.WithLabelValues(metrics.OutcomeInterrupted).Inc()
+	} else if skipped {
+		slog.Warn("Backup run skipped", "id", state.ID, "error", err)
+		metrics.RunsTotal.WithLabelValues(metrics.OutcomeSkipped).Inc()
+	} else if err != nil {
+		slog.Error("Backup run failed", "id", state.ID, "error", err)
+		metrics.RunsTotal.WithLabelValues(metrics.OutcomeFailure).Inc()
+		heartbeat.Failure(duration, err)
+	} else {
+		slog.Info("Backup run succeeded", "id", state.ID, "duration", duration)
+		metrics.RunsTotal.WithLabelValues(metrics.OutcomeSuccess).Inc()
+		heartbeat.Success(duration)
+	}
+}
+
+// backupAndPurge backs up all dirs, or the remaining dirs of an interrupted
+// run, and purges old backups if requested. When interrupted, the remaining
+// dirs are saved so that the next run can continue into the same snapshot.
+func backupAndPurge(ctx context.Context, state *RunState, withPurge bool, last LastSuccess) ([]DirResult, *PurgeResult, error) {
+	pending, err := LoadInterrupted()
+	if err != nil {
+		slog.Warn("Ignoring unreadable interrupted state", "error", err)
+		pending = nil
+	}
+
+	s3, err := newS3(pending == nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dirs := config.Get().Backup.Dirs
+	var done []DirResult
+	var files []FileEntry
+	if pending != nil {
+		slog.Info("Resuming interrupted backup", "run", pending.RunID, "prefix", pending.Prefix, "dirs", pending.Dirs)
+		s3.Prefix = pending.Prefix
+		dirs = pending.Dirs
+		done = pending.Done
+		files = pending.Files
+		withPurge = withPurge || pending.Purge
+
+		stateMu.Lock()
+		state.ResumedFrom = pending.RunID
+		state.TotalDirs = len(dirs)
+		stateMu.Unlock()
+	}
+
+	results, err := Backup(ctx, s3, dirs, pending != nil, last)
+	if errors.Is(err, ErrInterrupted) {
+		saveInterrupted(Interrupted{
+			RunID:         state.ID,
+			Trigger:       state.Trigger,
+			Prefix:        s3.Prefix,
+			Dirs:          dirs[len(results):],
+			Done:          append(done, results...),
+			Files:         append(files, filesOf(results)...),
+			Purge:         withPurge,
+			InterruptedAt: time.Now(),
+		})
+		return results, nil, err
+	}
+	if pending != nil {
+		clearInterrupted()
+	}
+	if err != nil {
+		return results, nil, err
+	}
+
+	// A manifest alone must not count as a snapshot when every dir failed
+	all := append(done, results...)
+	if slices.ContainsFunc(all, func(r DirResult) bool { return r.Err == nil }) {
+		if err := writeManifest(s3, state.ID, all, append(files, filesOf(results)...)); err != nil {
+			slog.Error("Error writing snapshot manifest", "error", err)
+		}
+	}
+
+	if !withPurge {
+		return results, nil, nil
+	}
+
+	if ctx.Err() != nil {
+		slog.Warn("Skipping purge on shutdown")
+		return results, nil, nil
+	}
+
+	// Keep older snapshots around in case this one holds damaged files
+	stateMu.RLock()
+	suspicious := state.Anomaly != nil
+	stateMu.RUnlock()
+	if suspicious {
+		slog.Warn("Skipping purge, snapshot is suspicious")
+		return results, nil, nil
+	}
+
+	purge := PurgeOldBackups()
+	return results, &purge, nil
+}
+
+// trackDir records the dir currently being backed up by the active run.
+func trackDir(dir string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if current != nil {
+		current.CurrentDir = dir
+	}
+}
+
+// trackResult records a finished dir in the active run.
+func trackResult(result DirResult) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if current != nil {
+		current.DoneDirs++
+		current.Dirs = append(current.Dirs, result)
+	}
+}
+
+// trackAnomaly records that the active run was marked suspicious.
+func trackAnomaly(a *Anomaly) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if current != nil {
+		current.Anomaly = a
+	}
+}
+
+// runError joins the errors of all failed dirs and the purge.
+func runError(results []DirResult, purge *PurgeResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Dir, r.Err))
+		}
+	}
+
+	if purge != nil {
+		if purge.Err != nil {
+			errs = append(errs, fmt.Errorf("purge: %w", purge.Err))
+		}
+		for _, key := range purge.Failed {
+			errs = append(errs, fmt.Errorf("purge: failed to delete %s", key))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NotifyPurgeSummary sends the summary notification of a purge run on its
+// own.
+func NotifyPurgeSummary(purge PurgeResult) {
+	notifiers.NotifyPurgeSummary(purge.purge())
+}
+
+// notifySummary sends the run summary notification. purge is nil when no
+// purge was performed, err is set when the run failed outside of any dir,
+// e.g. before it could start. last is read from the run history before the
+// run.
+func notifySummary(results []DirResult, purge *PurgeResult, duration time.Duration, err error, last LastSuccess) {
+	summary := notifiers.Summary{Duration: duration, LastSuccess: last.Run}
+
+	for _, r := range results {
+		if r.Err != nil {
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+		summary.Dirs = append(summary.Dirs, r.event(last.Dirs[r.Dir]))
+	}
+
+	// Dirs that never ran count as failed
+	if err != nil {
+		summary.Error = err.Error()
+		summary.Failed = max(summary.Failed, len(config.Get().Backup.Dirs)-summary.Succeeded, 1)
+	}
+
+	if purge != nil {
+		p := purge.purge()
+		summary.Purge = &p
+	}
+
+	notifiers.NotifyRunSummary(summary)
+}
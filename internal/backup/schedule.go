@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	commonConstants "github.com/hibare/GoCommon/v2/pkg/constants"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/robfig/cron/v3"
+)
+
+// cronSchedule parses the backup cron the same way the scheduler does.
+func cronSchedule() (cron.Schedule, error) {
+	return cron.ParseStandard(fmt.Sprintf("CRON_TZ=%s %s", config.Get().Backup.Timezone, config.Get().Backup.Cron))
+}
+
+// JitterDelay returns how long a scheduled run waits before starting so that
+// hosts sharing a schedule don't hit the bucket at the same time. With
+// jitter-per-host the delay is derived from the hostname and stays the same
+// between runs.
+func JitterDelay() time.Duration {
+	jitter := config.Get().Backup.Jitter
+	if jitter <= 0 {
+		return 0
+	}
+
+	if config.Get().Backup.JitterPerHost {
+		h := fnv.New64a()
+		h.Write([]byte(config.Get().Backup.Hostname))
+		return time.Duration(h.Sum64() % uint64(jitter))
+	}
+
+	return rand.N(jitter)
+}
+
+// WaitJitter waits for JitterDelay, returning early with an error if ctx is
+// done.
+func WaitJitter(ctx context.Context) error {
+	delay := JitterDelay()
+	if delay <= 0 {
+		return nil
+	}
+
+	slog.Info("Delaying backup run", "delay", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// newestBackup returns the time of the newest snapshot, falling back to the
+// last successful run in the local history if the bucket can't be listed.
+func newestBackup() time.Time {
+	keys, err := listBackupKeys()
+	if err != nil {
+		slog.Warn("Error listing backups, using run history", "error", err)
+		return readLastSuccess().Run
+	}
+
+	if len(keys) == 0 {
+		return time.Time{}
+	}
+
+	newest, err := time.ParseInLocation(commonConstants.DefaultDateTimeLayout, keys[0], time.Local)
+	if err != nil {
+		slog.Warn("Error parsing backup datetime", "key", keys[0], "error", err)
+		return readLastSuccess().Run
+	}
+	return newest
+}
+
+// MissedSchedule reports whether a scheduled run was missed since the newest
+// backup, e.g. because the host was down at the scheduled time.
+func MissedSchedule() (bool, error) {
+	schedule, err := cronSchedule()
+	if err != nil {
+		return false, err
+	}
+
+	newest := newestBackup()
+	if newest.IsZero() {
+		slog.Info("No previous backup found")
+		return true, nil
+	}
+
+	// A run still within its jitter window hasn't been missed yet.
+	next := schedule.Next(newest)
+	if next.Add(config.Get().Backup.Jitter).After(time.Now()) {
+		return false, nil
+	}
+
+	slog.Info("Missed scheduled backup", "scheduled", next, "newest", newest)
+	return true, nil
+}
+
+// RunOnStart runs a backup at daemon start if configured to always do so or
+// to catch up on a missed schedule.
+func RunOnStart(ctx context.Context) error {
+	trigger := ""
+	switch {
+	case config.Get().Backup.RunOnStart:
+		trigger = TriggerStartup
+	case config.Get().Backup.CatchUp:
+		missed, err := MissedSchedule()
+		if err != nil {
+			return err
+		}
+		if missed {
+			trigger = TriggerCatchUp
+		}
+	}
+
+	if trigger == "" {
+		return nil
+	}
+
+	_, err := Run(ctx, trigger, true)
+	return err
+}
@@ -0,0 +1,146 @@
+package backup
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// sessions caches the session of the S3 config in use, so that credentials
+// e.g. of an assumed role are only fetched once and then refreshed by the
+// SDK as they expire.
+var sessions struct {
+	mu   sync.Mutex
+	c    config.S3Config
+	sess *session.Session
+}
+
+// cachedSession returns the session for c, reusing the previous one unless
+// the S3 config changed on reload.
+func cachedSession(c config.S3Config) (*session.Session, error) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	if sessions.sess != nil && sessions.c == c {
+		return sessions.sess, nil
+	}
+
+	sess, err := newSession(c)
+	if err != nil {
+		return nil, err
+	}
+	sessions.c, sessions.sess = c, sess
+	return sess, nil
+}
+
+// sessionOptions returns the options of the session credentials are
+// resolved with. The shared config file is only loaded for profile auth,
+// other auth types keep the SDK's default of honoring AWS_SDK_LOAD_CONFIG.
+func sessionOptions(c config.S3Config) session.Options {
+	opts := session.Options{
+		Config: aws.Config{Region: aws.String(c.Region)},
+	}
+	if c.Auth.Type == constants.S3AuthProfile {
+		opts.Profile = c.Auth.Profile
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	return opts
+}
+
+// newSession creates an AWS session for the configured bucket endpoint with
+// credentials resolved according to s3.auth.
+func newSession(c config.S3Config) (*session.Session, error) {
+	// STS and instance metadata must not be sent to a custom S3 endpoint,
+	// so credentials are resolved with a session on the default endpoints.
+	base, err := session.NewSessionWithOptions(sessionOptions(c))
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := newCredentials(base, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.NewSession(&aws.Config{
+		Region:           aws.String(c.Region),
+		Endpoint:         aws.String(c.Endpoint),
+		Credentials:      creds,
+		S3ForcePathStyle: aws.Bool(true),
+	})
+}
+
+func newCredentials(base *session.Session, c config.S3Config) (*credentials.Credentials, error) {
+	p, err := credentialsProvider(base, c)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		// The base session resolves env vars, the shared credentials file
+		// (and config of the selected profile), web identity and instance
+		// roles.
+		return base.Config.Credentials, nil
+	}
+	return credentials.NewCredentials(p), nil
+}
+
+// credentialsProvider returns the provider of credentials for the auth type
+// of c, or nil if those of the base session are used.
+func credentialsProvider(base *session.Session, c config.S3Config) (credentials.Provider, error) {
+	auth := c.Auth
+
+	switch auth.Type {
+	case constants.S3AuthStatic:
+		return &credentials.StaticProvider{Value: credentials.Value{
+			AccessKeyID:     c.AccessKey,
+			SecretAccessKey: c.SecretKey,
+		}}, nil
+
+	case constants.S3AuthDefault, constants.S3AuthProfile:
+		return nil, nil
+
+	case constants.S3AuthIMDS:
+		return &ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(base)}, nil
+
+	case constants.S3AuthWebIdentity:
+		roleARN := cmp.Or(auth.RoleARN, os.Getenv("AWS_ROLE_ARN"))
+		tokenFile := cmp.Or(auth.WebIdentityTokenFile, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+		if roleARN == "" || tokenFile == "" {
+			return nil, fmt.Errorf("%w: %s requires role-arn and web-identity-token-file", ErrInvalidAuth, auth.Type)
+		}
+		return stscreds.NewWebIdentityRoleProvider(sts.New(base), roleARN, auth.SessionName, tokenFile), nil
+
+	case constants.S3AuthAssumeRole:
+		// Static keys, if set, are the identity assuming the role.
+		source := base
+		if c.AccessKey != "" {
+			source = base.Copy(&aws.Config{
+				Credentials: credentials.NewStaticCredentials(c.AccessKey, c.SecretKey, ""),
+			})
+		}
+
+		p := &stscreds.AssumeRoleProvider{
+			Client:          sts.New(source),
+			RoleARN:         auth.RoleARN,
+			RoleSessionName: auth.SessionName,
+			Duration:        stscreds.DefaultDuration,
+		}
+		if auth.ExternalID != "" {
+			p.ExternalID = aws.String(auth.ExternalID)
+		}
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidAuth, auth.Type)
+}
@@ -0,0 +1,337 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+func TestSessionOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		auth        config.S3AuthConfig
+		wantProfile string
+		wantShared  session.SharedConfigState
+	}{
+		{"static", config.S3AuthConfig{Type: constants.S3AuthStatic, Profile: "work"}, "", session.SharedConfigStateFromEnv},
+		{"default", config.S3AuthConfig{Type: constants.S3AuthDefault}, "", session.SharedConfigStateFromEnv},
+		{"assume-role", config.S3AuthConfig{Type: constants.S3AuthAssumeRole, Profile: "work"}, "", session.SharedConfigStateFromEnv},
+		{"profile", config.S3AuthConfig{Type: constants.S3AuthProfile, Profile: "work"}, "work", session.SharedConfigEnable},
+		{"profile from env", config.S3AuthConfig{Type: constants.S3AuthProfile}, "", session.SharedConfigEnable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := sessionOptions(config.S3Config{Region: "eu-west-1", Auth: tt.auth})
+			if opts.Profile != tt.wantProfile || opts.SharedConfigState != tt.wantShared {
+				t.Errorf("sessionOptions() profile %q, shared config %v, want %q, %v", opts.Profile, opts.SharedConfigState, tt.wantProfile, tt.wantShared)
+			}
+			if aws.StringValue(opts.Config.Region) != "eu-west-1" {
+				t.Errorf("sessionOptions() region %q, want eu-west-1", aws.StringValue(opts.Config.Region))
+			}
+		})
+	}
+}
+
+func TestCredentialsProvider(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+
+	base, err := session.NewSession(&aws.Config{Region: aws.String("us-east-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		c       config.S3Config
+		wantErr error
+		check   func(t *testing.T, p credentials.Provider)
+	}{
+		{
+			name: "static",
+			c:    config.S3Config{AccessKey: "AK", SecretKey: "SK", Auth: config.S3AuthConfig{Type: constants.S3AuthStatic}},
+			check: func(t *testing.T, p credentials.Provider) {
+				s, ok := p.(*credentials.StaticProvider)
+				if !ok || s.AccessKeyID != "AK" || s.SecretAccessKey != "SK" {
+					t.Errorf("provider = %#v, want static AK/SK", p)
+				}
+			},
+		},
+		{
+			name: "default uses base session",
+			c:    config.S3Config{AccessKey: "AK", Auth: config.S3AuthConfig{Type: constants.S3AuthDefault}},
+			check: func(t *testing.T, p credentials.Provider) {
+				if p != nil {
+					t.Errorf("provider = %#v, want nil", p)
+				}
+			},
+		},
+		{
+			name: "profile uses base session",
+			c:    config.S3Config{Auth: config.S3AuthConfig{Type: constants.S3AuthProfile, Profile: "work"}},
+			check: func(t *testing.T, p credentials.Provider) {
+				if p != nil {
+					t.Errorf("provider = %#v, want nil", p)
+				}
+			},
+		},
+		{
+			name: "imds",
+			c:    config.S3Config{Auth: config.S3AuthConfig{Type: constants.S3AuthIMDS}},
+			check: func(t *testing.T, p credentials.Provider) {
+				if _, ok := p.(*ec2rolecreds.EC2RoleProvider); !ok {
+					t.Errorf("provider = %#v, want EC2 role provider", p)
+				}
+			},
+		},
+		{
+			name: "web identity",
+			c: config.S3Config{Auth: config.S3AuthConfig{
+				Type: constants.S3AuthWebIdentity, RoleARN: "arn:aws:iam::1:role/r", WebIdentityTokenFile: "/token",
+			}},
+			check: func(t *testing.T, p credentials.Provider) {
+				if _, ok := p.(*stscreds.WebIdentityRoleProvider); !ok {
+					t.Errorf("provider = %#v, want web identity provider", p)
+				}
+			},
+		},
+		{
+			name:    "web identity without token file",
+			c:       config.S3Config{Auth: config.S3AuthConfig{Type: constants.S3AuthWebIdentity, RoleARN: "arn:aws:iam::1:role/r"}},
+			wantErr: ErrInvalidAuth,
+		},
+		{
+			name: "assume role",
+			c: config.S3Config{Auth: config.S3AuthConfig{
+				Type: constants.S3AuthAssumeRole, RoleARN: "arn:aws:iam::1:role/r", SessionName: "s", ExternalID: "x",
+			}},
+			check: func(t *testing.T, p credentials.Provider) {
+				a, ok := p.(*stscreds.AssumeRoleProvider)
+				if !ok || a.RoleARN != "arn:aws:iam::1:role/r" || a.RoleSessionName != "s" || aws.StringValue(a.ExternalID) != "x" {
+					t.Errorf("provider = %#v, want assume role provider", p)
+				}
+			},
+		},
+		{
+			name: "assume role with static keys",
+			c: config.S3Config{AccessKey: "AK", SecretKey: "SK", Auth: config.S3AuthConfig{
+				Type: constants.S3AuthAssumeRole, RoleARN: "arn:aws:iam::1:role/r",
+			}},
+			check: func(t *testing.T, p credentials.Provider) {
+				a, ok := p.(*stscreds.AssumeRoleProvider)
+				if !ok {
+					t.Fatalf("provider = %#v, want assume role provider", p)
+				}
+				v, err := a.Client.(*sts.STS).Config.Credentials.Get()
+				if err != nil || v.AccessKeyID != "AK" {
+					t.Errorf("source credentials = %v, %v, want AK", v.AccessKeyID, err)
+				}
+			},
+		},
+		{
+			name:    "unknown",
+			c:       config.S3Config{Auth: config.S3AuthConfig{Type: "bogus"}},
+			wantErr: ErrInvalidAuth,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := credentialsProvider(base, tt.c)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("credentialsProvider() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, p)
+			}
+		})
+	}
+}
+
+func TestCachedSession(t *testing.T) {
+	c := config.S3Config{Region: "us-east-1", Bucket: "b", AccessKey: "AK", SecretKey: "SK", Auth: config.S3AuthConfig{Type: constants.S3AuthStatic}}
+
+	first, err := cachedSession(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := cachedSession(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != same {
+		t.Error("cachedSession() created a new session for the same config")
+	}
+
+	c.SecretKey = "changed"
+	changed, err := cachedSession(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first {
+		t.Error("cachedSession() reused the session after the config changed")
+	}
+}
+
+// newIMDS returns a stand-in for the instance metadata service serving
+// credentials of the role "backup", requiring an IMDSv2 token.
+func newIMDS(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	const token = "imds-token"
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds"))
+		fmt.Fprint(w, token)
+	})
+	mux.HandleFunc("GET /latest/meta-data/iam/security-credentials/{role...}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != token {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		switch r.PathValue("role") {
+		case "":
+			fmt.Fprint(w, "backup")
+		case "backup":
+			fmt.Fprintf(w, `{"Code":"Success","AccessKeyId":"AKIMDS","SecretAccessKey":"SKIMDS","Token":"session","Expiration":%q}`,
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newSTS returns a stand-in for STS answering action with credentials and
+// recording the form values of the last request.
+func newSTS(t *testing.T, action string, form *url.Values) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != action {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		*form = r.Form
+
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>AKSTS</AccessKeyId>
+      <SecretAccessKey>SKSTS</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>%[2]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`, action, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIMDSCredentials(t *testing.T) {
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", newIMDS(t).URL)
+
+	sess, err := newSession(config.S3Config{
+		Region:   "us-east-1",
+		Endpoint: "http://127.0.0.1:9000",
+		Auth:     config.S3AuthConfig{Type: constants.S3AuthIMDS},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := sess.Config.Credentials.Get()
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if v.AccessKeyID != "AKIMDS" || v.SecretAccessKey != "SKIMDS" || v.SessionToken != "session" {
+		t.Errorf("Get() = %+v, want the instance role credentials", v)
+	}
+}
+
+func TestSTSCredentials(t *testing.T) {
+	t.Setenv("AWS_ROLE_ARN", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("web-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		action   string
+		c        config.S3Config
+		wantForm map[string]string
+	}{
+		{
+			name:   "web identity",
+			action: "AssumeRoleWithWebIdentity",
+			c: config.S3Config{Auth: config.S3AuthConfig{
+				Type: constants.S3AuthWebIdentity, RoleARN: "arn:aws:iam::1:role/r", SessionName: "backup", WebIdentityTokenFile: tokenFile,
+			}},
+			wantForm: map[string]string{"RoleArn": "arn:aws:iam::1:role/r", "RoleSessionName": "backup", "WebIdentityToken": "web-token"},
+		},
+		{
+			name:   "assume role",
+			action: "AssumeRole",
+			c: config.S3Config{AccessKey: "AK", SecretKey: "SK", Auth: config.S3AuthConfig{
+				Type: constants.S3AuthAssumeRole, RoleARN: "arn:aws:iam::1:role/r", SessionName: "backup", ExternalID: "external",
+			}},
+			wantForm: map[string]string{"RoleArn": "arn:aws:iam::1:role/r", "RoleSessionName": "backup", "ExternalId": "external"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form url.Values
+			base, err := session.NewSession(&aws.Config{
+				Region:      aws.String("us-east-1"),
+				Endpoint:    aws.String(newSTS(t, tt.action, &form).URL),
+				Credentials: credentials.AnonymousCredentials,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := credentialsProvider(base, tt.c)
+			if err != nil {
+				t.Fatalf("credentialsProvider() error = %v", err)
+			}
+			v, err := credentials.NewCredentials(p).Get()
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if v.AccessKeyID != "AKSTS" || v.SecretAccessKey != "SKSTS" || v.SessionToken != "session" {
+				t.Errorf("Get() = %+v, want the STS credentials", v)
+			}
+			for k, want := range tt.wantForm {
+				if got := form.Get(k); got != want {
+					t.Errorf("request %s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	commonConstants "github.com/hibare/GoCommon/v2/pkg/constants"
+	commonGPG "github.com/hibare/GoCommon/v2/pkg/crypto/gpg"
+	commonDateTimes "github.com/hibare/GoCommon/v2/pkg/datetime"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// Snapshot describes a backup of the host in the bucket. A snapshot is
+// complete once its run finished and wrote the manifest, interrupted runs
+// and backups made before manifests were introduced are incomplete. It is
+// suspicious if the anomaly guard flagged its run.
+type Snapshot struct {
+	Key        string        `json:"key"`
+	Timestamp  time.Time     `json:"timestamp"`
+	Age        time.Duration `json:"-"`
+	Size       int64         `json:"size"`
+	Objects    int           `json:"objects"`
+	Encrypted  bool          `json:"encrypted"`
+	Complete   bool          `json:"complete"`
+	Suspicious bool          `json:"suspicious"`
+	Dirs       []string      `json:"dirs"`
+}
+
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	type snapshot Snapshot
+	return json.Marshal(struct {
+		snapshot
+		Age string `json:"age"`
+	}{snapshot(s), s.Age.Round(time.Second).String()})
+}
+
+// snapshotTime parses the timestamp of a snapshot key with the configured
+// date-time-layout, falling back to the layout keys are created with.
+func snapshotTime(key string) time.Time {
+	for _, layout := range []string{config.Get().Backup.DateTimeLayout, commonConstants.DefaultDateTimeLayout} {
+		if t, err := time.ParseInLocation(layout, key, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// snapshotDir returns the backed up dir an object of a snapshot belongs to,
+// i.e. the first path element without archive & encryption extensions.
+func snapshotDir(name string) string {
+	dir, _, _ := strings.Cut(name, "/")
+	dir = strings.TrimSuffix(dir, "."+commonGPG.GPGPrefix)
+	return strings.TrimSuffix(dir, ".zip")
+}
+
+// listBackupKeys returns the datetime keys of the host's backups, newest
+// first.
+func listBackupKeys() ([]string, error) {
+	var keys []string
+
+	s3, err := newS3(false)
+	if err != nil {
+		return keys, err
+	}
+
+	slog.Info("prefix", "prefix", s3.Prefix)
+
+	// Retrieve objects by prefix
+	keys, err = s3.ListObjectsAtPrefixRoot()
+	if err != nil {
+		slog.Error("Error listing objects", "error", err)
+		return keys, err
+	}
+
+	if len(keys) == 0 {
+		slog.Info("No backups found")
+		return keys, nil
+	}
+
+	slog.Info("Found backups", "keys", len(keys))
+
+	// Remove prefix from key to get datetime string, skipping anything
+	// that isn't a backup such as the lock object
+	keys = slices.DeleteFunc(s3.TrimPrefix(keys), func(key string) bool {
+		_, err := time.Parse(commonConstants.DefaultDateTimeLayout, key)
+		return err != nil
+	})
+
+	// Sort datetime strings by descending order
+	sortedKeys := commonDateTimes.SortDateTimes(keys)
+
+	return sortedKeys, nil
+}
+
+// ListBackups returns the host's backups, newest first, with the size,
+// object count, dirs & completeness of each.
+func ListBackups() ([]Snapshot, error) {
+	keys, err := listBackupKeys()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	s3, err := newS3(false)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	snapshots := make(map[string]*Snapshot, len(keys))
+	for _, key := range keys {
+		t := snapshotTime(key)
+		snapshots[key] = &Snapshot{Key: key, Timestamp: t, Age: now.Sub(t)}
+	}
+
+	// A single listing of the host prefix covers every snapshot
+	err = awsS3.New(s3.Sess).ListObjectsV2Pages(&awsS3.ListObjectsV2Input{
+		Bucket: aws.String(s3.Bucket),
+		Prefix: aws.String(s3.Prefix),
+	}, func(page *awsS3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			key, name, _ := strings.Cut(strings.TrimPrefix(aws.StringValue(obj.Key), s3.Prefix), "/")
+			snapshot, ok := snapshots[key]
+			if !ok || name == "" {
+				continue
+			}
+
+			switch name {
+			case constants.ManifestFileName:
+				snapshot.Complete = true
+				continue
+			case constants.SuspiciousFileName:
+				snapshot.Suspicious = true
+				continue
+			}
+
+			snapshot.Size += aws.Int64Value(obj.Size)
+			snapshot.Objects++
+			if strings.HasSuffix(name, "."+commonGPG.GPGPrefix) {
+				snapshot.Encrypted = true
+			}
+			if dir := snapshotDir(name); !slices.Contains(snapshot.Dirs, dir) {
+				snapshot.Dirs = append(snapshot.Dirs, dir)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Snapshot, 0, len(keys))
+	for _, key := range keys {
+		slices.Sort(snapshots[key].Dirs)
+		result = append(result, *snapshots[key])
+	}
+	return result, nil
+}
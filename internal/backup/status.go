@@ -0,0 +1,47 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+)
+
+// Status summarizes the backup state of the host from the local run history.
+type Status struct {
+	Hostname    string       `json:"hostname"`
+	Cron        string       `json:"cron"`
+	Timezone    string       `json:"timezone"`
+	NextRun     *time.Time   `json:"next_run,omitempty"`
+	LastRun     *RunState    `json:"last_run,omitempty"`
+	LastSuccess *time.Time   `json:"last_success,omitempty"`
+	Interrupted *Interrupted `json:"interrupted,omitempty"`
+}
+
+// GetStatus returns the schedule, last run and any interrupted run waiting to
+// be resumed.
+func GetStatus() (Status, error) {
+	status := Status{
+		Hostname: config.Get().Backup.Hostname,
+		Cron:     config.Get().Backup.Cron,
+		Timezone: config.Get().Backup.Timezone,
+	}
+
+	if schedule, err := cronSchedule(); err == nil {
+		next := schedule.Next(time.Now())
+		status.NextRun = &next
+	}
+
+	runs, err := History(HistoryFilter{})
+	if err != nil {
+		return status, err
+	}
+	if len(runs) > 0 {
+		status.LastRun = &runs[0]
+	}
+	if t := lastSuccess(runs).Run; !t.IsZero() {
+		status.LastSuccess = &t
+	}
+
+	status.Interrupted, err = LoadInterrupted()
+	return status, err
+}
@@ -0,0 +1,141 @@
+package backup
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	commonFiles "github.com/hibare/GoCommon/v2/pkg/file"
+	commonS3 "github.com/hibare/GoCommon/v2/pkg/s3"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// uploadFile uploads filePath to the snapshot prefix. If ctx is canceled
+// mid-upload, any multipart upload left behind is aborted.
+func uploadFile(ctx context.Context, s3 *commonS3.S3, filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	key := filepath.Join(s3.Prefix, filepath.Base(filePath))
+	if _, err := s3manager.NewUploader(s3.Sess).UploadWithContext(ctx, newObjectLock().upload(&s3manager.UploadInput{
+		Bucket: aws.String(s3.Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})); err != nil {
+		if ctx.Err() != nil {
+			abortUploads(s3, key)
+		}
+		return "", err
+	}
+
+	return key, nil
+}
+
+// uploadDir uploads every file under baseDir to the snapshot prefix, stopping
+// once ctx is canceled. When resuming, files already present with the same
+// size are skipped.
+func uploadDir(ctx context.Context, s3 *commonS3.S3, baseDir string, resume bool) (string, int, int, int, error) {
+	client := awsS3.New(s3.Sess)
+	baseDirParentPath := filepath.Dir(baseDir)
+
+	files, dirs := commonFiles.ListFilesDirs(baseDir, nil)
+	totalFiles, totalDirs, successFiles := len(files), len(dirs), 0
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return "", totalFiles, totalDirs, successFiles, err
+		}
+
+		key := filepath.Join(s3.Prefix, strings.TrimPrefix(file, baseDirParentPath))
+		if resume && uploaded(ctx, client, s3.Bucket, key, file) {
+			successFiles++
+			continue
+		}
+
+		if err := putFile(ctx, client, s3.Bucket, key, file); err != nil {
+			if ctx.Err() != nil {
+				return "", totalFiles, totalDirs, successFiles, ctx.Err()
+			}
+			slog.Warn("Error uploading file", "file", file, "error", err)
+			continue
+		}
+		successFiles++
+	}
+
+	baseKey := ""
+	if successFiles > 0 {
+		baseKey = filepath.Join(s3.Prefix, filepath.Base(baseDir))
+	}
+
+	return baseKey, totalFiles, totalDirs, successFiles, nil
+}
+
+func putFile(ctx context.Context, client *awsS3.S3, bucket, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = client.PutObjectWithContext(ctx, newObjectLock().putObject(&awsS3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	}))
+	return err
+}
+
+// uploaded reports whether key already exists with the size of file.
+func uploaded(ctx context.Context, client *awsS3.S3, bucket, key, file string) bool {
+	info, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+
+	out, err := client.HeadObjectWithContext(ctx, &awsS3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false
+	}
+
+	return aws.Int64Value(out.ContentLength) == info.Size()
+}
+
+// abortUploads aborts unfinished multipart uploads for key. It uses its own
+// context as the upload's context is already canceled.
+func abortUploads(s3 *commonS3.S3, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AbortUploadsTimeout)
+	defer cancel()
+
+	client := awsS3.New(s3.Sess)
+	out, err := client.ListMultipartUploadsWithContext(ctx, &awsS3.ListMultipartUploadsInput{
+		Bucket: aws.String(s3.Bucket),
+		Prefix: aws.String(key),
+	})
+	if err != nil {
+		slog.Error("Error listing multipart uploads", "key", key, "error", err)
+		return
+	}
+
+	for _, upload := range out.Uploads {
+		if _, err := client.AbortMultipartUploadWithContext(ctx, &awsS3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s3.Bucket),
+			Key:      upload.Key,
+			UploadId: upload.UploadId,
+		}); err != nil {
+			slog.Error("Error aborting multipart upload", "key", aws.StringValue(upload.Key), "error", err)
+			continue
+		}
+		slog.Info("Aborted multipart upload", "key", aws.StringValue(upload.Key))
+	}
+}
@@ -1,22 +1,42 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	commonConfig "github.com/hibare/GoCommon/v2/pkg/config"
 	commonLogger "github.com/hibare/GoCommon/v2/pkg/logger"
 	commonUtils "github.com/hibare/GoCommon/v2/pkg/utils"
 	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
 )
 
+type S3AuthConfig struct {
+	Type                 string `yaml:"type" mapstructure:"type"`
+	Profile              string `yaml:"profile,omitempty" mapstructure:"profile"`
+	RoleARN              string `yaml:"role-arn,omitempty" mapstructure:"role-arn"`
+	ExternalID           string `yaml:"external-id,omitempty" mapstructure:"external-id" secret:"true"`
+	SessionName          string `yaml:"session-name,omitempty" mapstructure:"session-name"`
+	WebIdentityTokenFile string `yaml:"web-identity-token-file,omitempty" mapstructure:"web-identity-token-file"`
+}
+
 type S3Config struct {
-	Endpoint  string `yaml:"endpoint" mapstructure:"endpoint"`
-	Region    string `yaml:"region" mapstructure:"region"`
-	AccessKey string `yaml:"access-key" mapstructure:"access-key"`
-	SecretKey string `yaml:"secret-key" mapstructure:"secret-key"`
-	Bucket    string `yaml:"bucket" mapstructure:"bucket"`
-	Prefix    string `yaml:"prefix" mapstructure:"prefix"`
+	Endpoint  string       `yaml:"endpoint" mapstructure:"endpoint"`
+	Region    string       `yaml:"region" mapstructure:"region"`
+	AccessKey string       `yaml:"access-key" mapstructure:"access-key"`
+	SecretKey string       `yaml:"secret-key" mapstructure:"secret-key" secret:"true"`
+	Bucket    string       `yaml:"bucket" mapstructure:"bucket"`
+	Prefix    string       `yaml:"prefix" mapstructure:"prefix"`
+	Auth      S3AuthConfig `yaml:"auth" mapstructure:"auth"`
 }
 
 type GPGConfig struct {
@@ -29,119 +49,404 @@ type Encryption struct {
 	GPG     GPGConfig
 }
 
+// AnomalyConfig sets the thresholds at which a run compared with the
+// previous snapshot is considered suspicious.
+type AnomalyConfig struct {
+	Enabled          bool    `yaml:"enabled" mapstructure:"enabled"`
+	MinFiles         int     `yaml:"min-files" mapstructure:"min-files"`
+	MaxModifiedRatio float64 `yaml:"max-modified-ratio" mapstructure:"max-modified-ratio"`
+	MaxEntropy       float64 `yaml:"max-entropy" mapstructure:"max-entropy"`
+	MaxSizeChange    float64 `yaml:"max-size-change" mapstructure:"max-size-change"`
+	MinChangedFiles  int     `yaml:"min-changed-files" mapstructure:"min-changed-files"`
+}
+
+// Check returns an error if a threshold is out of range. Zero values are
+// valid and replaced with defaults by Load.
+func (a AnomalyConfig) Check() error {
+	if a.MaxModifiedRatio < 0 || a.MaxModifiedRatio > 1 {
+		return fmt.Errorf("invalid anomaly max-modified-ratio: %v is not between 0 and 1", a.MaxModifiedRatio)
+	}
+	if a.MaxEntropy < 0 || a.MaxEntropy > 8 {
+		return fmt.Errorf("invalid anomaly max-entropy: %v is not between 0 and 8 bits per byte", a.MaxEntropy)
+	}
+	if a.MaxSizeChange < 0 {
+		return fmt.Errorf("invalid anomaly max-size-change: %v is negative", a.MaxSizeChange)
+	}
+	return nil
+}
+
+// ObjectLockConfig sets the S3 Object Lock retention and legal hold applied
+// to uploads, the bucket must have Object Lock enabled. Objects are retained
+// until the run that would purge them under the retention policy.
+type ObjectLockConfig struct {
+	Enabled   bool   `yaml:"enabled" mapstructure:"enabled"`
+	Mode      string `yaml:"mode" mapstructure:"mode"`
+	LegalHold bool   `yaml:"legal-hold" mapstructure:"legal-hold"`
+}
+
+// Check returns an error if the retention mode is unknown. An empty mode is
+// valid and replaced with the default by Load.
+func (l ObjectLockConfig) Check() error {
+	if l.Mode != "" && !slices.Contains(constants.ObjectLockModes, l.Mode) {
+		return fmt.Errorf("invalid object lock mode: %s, expected one of %v", l.Mode, constants.ObjectLockModes)
+	}
+	return nil
+}
+
+// RetentionConfig sets how many backups are kept, older ones are purged.
+type RetentionConfig struct {
+	Count int `yaml:"count" mapstructure:"count"`
+}
+
 type BackupConfig struct {
-	Dirs           []string   `yaml:"dirs" mapstructure:"dirs"`
-	Hostname       string     `yaml:"-"`
-	RetentionCount int        `yaml:"retention-count" mapstructure:"retention-count"`
-	DateTimeLayout string     `yaml:"date-time-layout" mapstructure:"date-time-layout"`
-	Cron           string     `yaml:"cron" mapstructure:"cron"`
-	ArchiveDirs    bool       `yaml:"archive-dirs" mapstructure:"archive-dirs"`
-	Encryption     Encryption `yaml:"encryption" mapstructure:"encryption"`
+	Dirs           []string         `yaml:"dirs" mapstructure:"dirs"`
+	Hostname       string           `yaml:"-"`
+	Retention      RetentionConfig  `yaml:"retention" mapstructure:"retention"`
+	DateTimeLayout string           `yaml:"date-time-layout" mapstructure:"date-time-layout"`
+	Cron           string           `yaml:"cron" mapstructure:"cron"`
+	ArchiveDirs    bool             `yaml:"archive-dirs" mapstructure:"archive-dirs"`
+	Encryption     Encryption       `yaml:"encryption" mapstructure:"encryption"`
+	LockTTL        time.Duration    `yaml:"lock-ttl" mapstructure:"lock-ttl"`
+	ShutdownGrace  time.Duration    `yaml:"shutdown-grace" mapstructure:"shutdown-grace"`
+	RunOnStart     bool             `yaml:"run-on-start" mapstructure:"run-on-start"`
+	CatchUp        bool             `yaml:"catch-up" mapstructure:"catch-up"`
+	Timezone       string           `yaml:"timezone" mapstructure:"timezone"`
+	Location       *time.Location   `yaml:"-" mapstructure:"-"`
+	Jitter         time.Duration    `yaml:"jitter" mapstructure:"jitter"`
+	JitterPerHost  bool             `yaml:"jitter-per-host" mapstructure:"jitter-per-host"`
+	Anomaly        AnomalyConfig    `yaml:"anomaly" mapstructure:"anomaly"`
+	ObjectLock     ObjectLockConfig `yaml:"object-lock" mapstructure:"object-lock"`
+}
+
+type DiscordTemplateFieldConfig struct {
+	Name   string `yaml:"name" mapstructure:"name"`
+	Value  string `yaml:"value" mapstructure:"value"`
+	Inline bool   `yaml:"inline" mapstructure:"inline"`
+}
+
+// DiscordTemplateConfig overrides the message rendered for a notifier event.
+// Empty values fall back to the built-in template for the event.
+type DiscordTemplateConfig struct {
+	Content     string                       `yaml:"content,omitempty" mapstructure:"content"`
+	Title       string                       `yaml:"title,omitempty" mapstructure:"title"`
+	Description string                       `yaml:"description,omitempty" mapstructure:"description"`
+	Color       string                       `yaml:"color,omitempty" mapstructure:"color"`
+	Footer      string                       `yaml:"footer,omitempty" mapstructure:"footer"`
+	Fields      []DiscordTemplateFieldConfig `yaml:"fields,omitempty" mapstructure:"fields"`
 }
 
 type DiscordNotifierConfig struct {
-	Enabled bool   `yaml:"enabled" mapstructure:"enabled"`
-	Webhook string `yaml:"webhook" mapstructure:"webhook"`
+	Enabled   bool                             `yaml:"enabled" mapstructure:"enabled"`
+	Webhook   string                           `yaml:"webhook" mapstructure:"webhook" secret:"true"`
+	Templates map[string]DiscordTemplateConfig `yaml:"templates,omitempty" mapstructure:"templates"`
 }
 
 type NotifiersConfig struct {
 	Enabled bool                  `yaml:"enabled" mapstructure:"enabled"`
+	Mode    string                `yaml:"mode" mapstructure:"mode"`
 	Discord DiscordNotifierConfig `yaml:"discord" mapstructure:"discord"`
 }
 
+type HeartbeatConfig struct {
+	Enabled bool   `yaml:"enabled" mapstructure:"enabled"`
+	Type    string `yaml:"type" mapstructure:"type"`
+	URL     string `yaml:"url" mapstructure:"url" secret:"true"`
+}
+
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" mapstructure:"enabled"`
+	Listen  string `yaml:"listen" mapstructure:"listen"`
+}
+
+type APIConfig struct {
+	Enabled bool   `yaml:"enabled" mapstructure:"enabled"`
+	Listen  string `yaml:"listen" mapstructure:"listen"`
+	Token   string `yaml:"token" mapstructure:"token" secret:"true"`
+}
+
 type LoggerConfig struct {
 	Level string `yaml:"level" mapstructure:"level"`
 	Mode  string `yaml:"mode" mapstructure:"mode"`
 }
 
 type Config struct {
+	Version   int             `yaml:"version" mapstructure:"version"`
 	S3        S3Config        `yaml:"s3" mapstructure:"s3"`
 	Backup    BackupConfig    `yaml:"backup" mapstructure:"backup"`
 	Notifiers NotifiersConfig `yaml:"notifiers" mapstructure:"notifiers"`
+	Heartbeat HeartbeatConfig `yaml:"heartbeat" mapstructure:"heartbeat"`
+	Metrics   MetricsConfig   `yaml:"metrics" mapstructure:"metrics"`
+	API       APIConfig       `yaml:"api" mapstructure:"api"`
 	Logger    LoggerConfig    `yaml:"logger" mapstructure:"logger"`
+
+	// sources maps the yaml path of every field to where its value came
+	// from.
+	sources map[string]string
+	// fileVersion is the schema version of the file before any in-memory
+	// migration.
+	fileVersion int
+}
+
+// FileVersion returns the schema version of the config file as found on
+// disk, before any in-memory migration.
+func (c *Config) FileVersion() int {
+	return c.fileVersion
 }
 
-var Current *Config
+// current is the config in use, swapped as a whole on reload.
+var current atomic.Pointer[Config]
+
+// Get returns the config in use. A reload swaps in a new config rather than
+// modifying the one returned.
+func Get() *Config {
+	return current.Load()
+}
+
+// Set makes c the config in use.
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// Apply makes c the config in use and sets up logging as configured in c.
+func Apply(c *Config) {
+	initLogger(c.Logger.Level, c.Logger.Mode)
+	Set(c)
+}
 
 var BC commonConfig.BaseConfig
 
-func LoadConfig() {
-	current, err := BC.ReadYAMLConfig(Current)
+// ReadConfig parses the config file as is, without applying defaults.
+// Files with an older schema version are migrated in memory.
+// ${VAR} references in the file are interpolated and fields are overridden
+// by GOS3BACKUP_* environment variables or their _FILE variants.
+func ReadConfig() (*Config, error) {
+	data, err := os.ReadFile(BC.ConfigFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older layouts are upgraded in memory, the file is left as is
+	migrated, err := upgrade(data)
+	if err != nil {
+		return nil, err
+	}
+	if migrated.From < migrated.To {
+		slog.Warn("Config file uses an older schema version, run `config migrate --write` to upgrade it", "version", migrated.From, "current", migrated.To)
+	}
+
+	data, err = interpolateEnv(migrated.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	v.SetConfigType(BC.ConfigFileExtension)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	current := &Config{}
+	if err := v.Unmarshal(current); err != nil {
+		return nil, err
+	}
+
+	overridden, err := applyEnv(reflect.ValueOf(current).Elem())
 	if err != nil {
-		log.Fatalf("Error reading config file: %s", err)
+		return nil, err
+	}
+
+	current.sources = resolveSources(current, v, overridden)
+	current.fileVersion = migrated.From
+
+	return current, nil
+}
+
+// Load reads the config file, applies defaults and checks the settings.
+// Settings that can't be used are disabled with a warning, invalid ones are
+// returned as an error. Nothing is changed until the result is applied.
+func Load() (*Config, error) {
+	c, err := ReadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
 	}
-	Current = current.(*Config)
 
 	// Check if logger.level & logger.mode are correct
-	if Current.Logger.Level == "" {
-		Current.Logger.Level = commonLogger.DefaultLoggerLevel
-	} else if Current.Logger.Level != "" {
-		if !commonLogger.IsValidLogLevel(Current.Logger.Level) {
-			log.Fatalf("Error invalid logger level: %s", Current.Logger.Level)
+	if c.Logger.Level == "" {
+		c.Logger.Level = commonLogger.DefaultLoggerLevel
+	} else if c.Logger.Level != "" {
+		if !commonLogger.IsValidLogLevel(c.Logger.Level) {
+			return nil, fmt.Errorf("invalid logger level: %s", c.Logger.Level)
 		}
 	}
 
-	if Current.Logger.Mode == "" {
-		Current.Logger.Mode = commonLogger.DefaultLoggerMode
-	} else if Current.Logger.Mode != "" {
-		if !commonLogger.IsValidLogMode(Current.Logger.Mode) {
-			log.Fatalf("Error invalid logger mode: %s", Current.Logger.Mode)
+	if c.Logger.Mode == "" {
+		c.Logger.Mode = commonLogger.DefaultLoggerMode
+	} else if c.Logger.Mode != "" {
+		if !commonLogger.IsValidLogMode(c.Logger.Mode) {
+			return nil, fmt.Errorf("invalid logger mode: %s", c.Logger.Mode)
 		}
 	}
 
-	commonLogger.InitLogger(&Current.Logger.Level, &Current.Logger.Mode)
+	// Set S3 auth type if missing & check required settings
+	if c.S3.Auth.Type == "" {
+		c.S3.Auth.Type = constants.DefaultS3AuthType
+	} else if !slices.Contains(constants.S3AuthTypes, c.S3.Auth.Type) {
+		return nil, fmt.Errorf("invalid s3 auth type: %s", c.S3.Auth.Type)
+	}
+
+	if c.S3.Auth.Type == constants.S3AuthAssumeRole && c.S3.Auth.RoleARN == "" {
+		return nil, fmt.Errorf("s3 auth type %s requires role-arn", c.S3.Auth.Type)
+	}
+
+	if c.S3.Auth.SessionName == "" {
+		c.S3.Auth.SessionName = constants.DefaultRoleSessionName
+	}
 
 	// Set default DateTimeLayout if missing
-	if Current.Backup.DateTimeLayout == "" {
+	if c.Backup.DateTimeLayout == "" {
 		slog.Warn("DateTimeLayout is not set, using default", "default", constants.DefaultDateTimeLayout)
-		Current.Backup.DateTimeLayout = constants.DefaultDateTimeLayout
+		c.Backup.DateTimeLayout = constants.DefaultDateTimeLayout
 	}
 
-	// Set RetentionCount if missing
-	if Current.Backup.RetentionCount == 0 {
-		slog.Warn("RetentionCount is not set, using default", "default", constants.DefaultRetentionCount)
-		Current.Backup.RetentionCount = constants.DefaultRetentionCount
+	// Set retention count if missing
+	if c.Backup.Retention.Count == 0 {
+		slog.Warn("Retention count is not set, using default", "default", constants.DefaultRetentionCount)
+		c.Backup.Retention.Count = constants.DefaultRetentionCount
 	}
 
 	// Set Schedule if missing
-	if Current.Backup.Cron == "" {
+	if c.Backup.Cron == "" {
 		slog.Warn("Schedule is not set, using default", "default", constants.DefaultCron)
-		Current.Backup.Cron = constants.DefaultCron
+		c.Backup.Cron = constants.DefaultCron
+	}
+
+	// Set Timezone if missing & resolve its location
+	if c.Backup.Timezone == "" {
+		c.Backup.Timezone = constants.DefaultTimezone
+	}
+	c.Backup.Location, err = time.LoadLocation(c.Backup.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %s", c.Backup.Timezone)
+	}
+
+	if _, err := cron.ParseStandard(fmt.Sprintf("CRON_TZ=%s %s", c.Backup.Timezone, c.Backup.Cron)); err != nil {
+		return nil, fmt.Errorf("invalid cron %q: %w", c.Backup.Cron, err)
+	}
+
+	if c.Backup.Jitter < 0 {
+		return nil, fmt.Errorf("invalid jitter: %s", c.Backup.Jitter)
+	}
+
+	// Set LockTTL if missing
+	if c.Backup.LockTTL <= 0 {
+		c.Backup.LockTTL = constants.DefaultLockTTL
+	}
+
+	// Set ShutdownGrace if missing
+	if c.Backup.ShutdownGrace <= 0 {
+		c.Backup.ShutdownGrace = constants.DefaultShutdownGrace
+	}
+
+	// Set anomaly thresholds if missing & check their range
+	if c.Backup.Anomaly.MinFiles <= 0 {
+		c.Backup.Anomaly.MinFiles = constants.DefaultAnomalyMinFiles
+	}
+	if c.Backup.Anomaly.MinChangedFiles <= 0 {
+		c.Backup.Anomaly.MinChangedFiles = constants.DefaultAnomalyMinChangedFiles
+	}
+	if err := c.Backup.Anomaly.Check(); err != nil {
+		return nil, err
+	}
+	if c.Backup.Anomaly.MaxModifiedRatio == 0 {
+		c.Backup.Anomaly.MaxModifiedRatio = constants.DefaultAnomalyMaxModifiedRatio
+	}
+	if c.Backup.Anomaly.MaxEntropy == 0 {
+		c.Backup.Anomaly.MaxEntropy = constants.DefaultAnomalyMaxEntropy
+	}
+	if c.Backup.Anomaly.MaxSizeChange == 0 {
+		c.Backup.Anomaly.MaxSizeChange = constants.DefaultAnomalyMaxSizeChange
+	}
+
+	// Set object lock mode if missing
+	if err := c.Backup.ObjectLock.Check(); err != nil {
+		return nil, err
+	}
+	if c.Backup.ObjectLock.Mode == "" {
+		c.Backup.ObjectLock.Mode = constants.DefaultObjectLockMode
+	}
+
+	// Set notifier mode if missing
+	if c.Notifiers.Mode == "" {
+		c.Notifiers.Mode = constants.DefaultNotifierMode
+	} else if !slices.Contains(constants.NotifierModes, c.Notifiers.Mode) {
+		return nil, fmt.Errorf("invalid notifier mode: %s", c.Notifiers.Mode)
 	}
 
 	// If notifier webhook is empty, set status to disable
-	if Current.Notifiers.Discord.Webhook == "" {
-		Current.Notifiers.Discord.Enabled = false
+	if c.Notifiers.Discord.Webhook == "" {
+		c.Notifiers.Discord.Enabled = false
+	}
+
+	// Set heartbeat type if missing
+	if c.Heartbeat.Type == "" {
+		c.Heartbeat.Type = constants.DefaultHeartbeatType
+	} else if !slices.Contains(constants.HeartbeatTypes, c.Heartbeat.Type) {
+		return nil, fmt.Errorf("invalid heartbeat type: %s", c.Heartbeat.Type)
+	}
+
+	// If heartbeat url is empty, set status to disable
+	if c.Heartbeat.Enabled && c.Heartbeat.URL == "" {
+		slog.Warn("Heartbeat is enabled but URL is missing. Disabling heartbeat")
+		c.Heartbeat.Enabled = false
+	}
+
+	// Set metrics listen address if missing
+	if c.Metrics.Listen == "" {
+		c.Metrics.Listen = constants.DefaultMetricsListen
+	}
+
+	// Set API listen address if missing
+	if c.API.Listen == "" {
+		c.API.Listen = constants.DefaultAPIListen
+	}
+
+	// API must not be served without authentication
+	if c.API.Enabled && c.API.Token == "" {
+		slog.Error("API is enabled but token is missing. Disabling API")
+		c.API.Enabled = false
 	}
 
 	// Check if encryption is enabled & encryption config is enabled
-	if Current.Backup.Encryption.Enabled && !Current.Backup.ArchiveDirs {
+	if c.Backup.Encryption.Enabled && !c.Backup.ArchiveDirs {
 		slog.Warn("Backup encryption is only available when archive dirs are enabled. Disabling encryption")
-		Current.Backup.Encryption.Enabled = false
-	} else if Current.Backup.Encryption.Enabled {
-		if Current.Backup.Encryption.GPG.KeyServer == "" || Current.Backup.Encryption.GPG.KeyID == "" {
+		c.Backup.Encryption.Enabled = false
+	} else if c.Backup.Encryption.Enabled {
+		if c.Backup.Encryption.GPG.KeyServer == "" || c.Backup.Encryption.GPG.KeyID == "" {
 			slog.Error("Encryption is enabled but GPG key server or key ID is missing")
-			Current.Backup.Encryption.Enabled = false
+			c.Backup.Encryption.Enabled = false
 		}
 	}
 
-	Current.Backup.Hostname = commonUtils.GetHostname()
-}
+	c.Backup.Hostname = commonUtils.GetHostname()
 
-func CleanConfig() error {
-	return BC.CleanConfig()
+	return c, nil
 }
 
-func InitConfig() error {
-	if Current == nil {
-		Current = &Config{}
-	}
+// LoadConfig loads the config and makes it the one in use, exiting on error.
+// Logs are written to w, also after reloads.
+func LoadConfig(w io.Writer) {
+	logOutput = w
+	initLogger(commonLogger.DefaultLoggerLevel, commonLogger.DefaultLoggerMode)
 
-	if err := BC.WriteYAMLConfig(Current); err != nil {
-		return err
+	c, err := Load()
+	if err != nil {
+		log.Fatalf("Error %s", err)
 	}
+	Apply(c)
+}
 
-	return nil
+func CleanConfig() error {
+	return BC.CleanConfig()
 }
 
 func init() {
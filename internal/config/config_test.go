@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnomalyConfigCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		anomaly AnomalyConfig
+		wantErr bool
+	}{
+		{"zero values", AnomalyConfig{}, false},
+		{"in range", AnomalyConfig{MaxModifiedRatio: 1, MaxEntropy: 8, MaxSizeChange: 10}, false},
+		{"disabled still checked", AnomalyConfig{Enabled: false, MaxEntropy: 9}, true},
+		{"negative modified ratio", AnomalyConfig{MaxModifiedRatio: -0.1}, true},
+		{"modified ratio above 1", AnomalyConfig{MaxModifiedRatio: 1.5}, true},
+		{"negative entropy", AnomalyConfig{MaxEntropy: -1}, true},
+		{"negative size change", AnomalyConfig{MaxSizeChange: -0.5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.anomaly.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestObjectLockConfigCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		lock    ObjectLockConfig
+		wantErr bool
+	}{
+		{"empty mode", ObjectLockConfig{}, false},
+		{"governance", ObjectLockConfig{Mode: "governance"}, false},
+		{"compliance", ObjectLockConfig{Mode: "compliance"}, false},
+		{"unknown mode", ObjectLockConfig{Mode: "bogus"}, true},
+		{"disabled still checked", ObjectLockConfig{Enabled: false, Mode: "bogus"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.lock.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadInvalidLeavesConfig(t *testing.T) {
+	previousPath := BC.ConfigFilePath
+	t.Cleanup(func() { BC.ConfigFilePath = previousPath })
+	BC.ConfigFilePath = filepath.Join(t.TempDir(), "config.yaml")
+
+	previous := Get()
+	t.Cleanup(func() { Set(previous) })
+	applied := &Config{Logger: LoggerConfig{Level: "INFO"}}
+	Set(applied)
+
+	data := "version: 1\nlogger:\n  level: LOUD\nbackup:\n  retention-count: 3\n"
+	if err := os.WriteFile(BC.ConfigFilePath, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(); err == nil {
+		t.Fatal("Load() error = nil, want invalid logger level")
+	}
+	if Get() != applied || Get().FileVersion() != 0 || Get().sources != nil {
+		t.Errorf("Load() changed the config in use: %+v", Get())
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"gopkg.in/yaml.v3"
+)
+
+// envRef matches ${VAR} references in the config file.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// interpolateEnv replaces ${VAR} references in the values of the YAML
+// document data with the value of the environment variable, or an empty
+// string if it is unset. Values are substituted after parsing so that they
+// are never interpreted as YAML. A bare $ is left untouched so that secrets
+// containing it survive.
+func interpolateEnv(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		return data, nil
+	}
+
+	var changed bool
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		switch n.Kind {
+		case yaml.ScalarNode:
+			if !envRef.MatchString(n.Value) {
+				return
+			}
+			n.Value = envRef.ReplaceAllStringFunc(n.Value, func(ref string) string {
+				return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+			})
+			changed = true
+		case yaml.MappingNode:
+			// Keys are left as is, only values are interpolated
+			for i := 1; i < len(n.Content); i += 2 {
+				walk(n.Content[i])
+			}
+		default:
+			for _, c := range n.Content {
+				walk(c)
+			}
+		}
+	}
+	walk(&doc)
+
+	if !changed {
+		return data, nil
+	}
+	return yaml.Marshal(&doc)
+}
+
+// envName returns the environment variable overriding the config field at
+// path, e.g. s3.secret-key is GOS3BACKUP_S3_SECRET_KEY.
+func envName(path string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(path)
+	return fmt.Sprintf("%s_%s", constants.EnvPrefix, strings.ToUpper(name))
+}
+
+// lookupEnv returns the override for the field at path from its environment
+// variable or, failing that, from the file named by its _FILE variant.
+func lookupEnv(path string) (string, bool, error) {
+	name := envName(path)
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+
+	file, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// walkFields calls fn for every leaf field of the struct v with its yaml
+// path, descending into nested structs. Fields tagged yaml:"-" are skipped.
+func walkFields(v reflect.Value, prefix string, fn func(path string, field reflect.StructField, fv reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			// Same default key as yaml
+			tag = strings.ToLower(field.Name)
+		}
+
+		path := tag
+		if prefix != "" {
+			path = prefix + "." + tag
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := walkFields(fv, path, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fn(path, field, fv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyEnv overrides fields of the struct v with environment variables named
+// after their yaml paths and returns the paths that were overridden. Maps
+// are not supported.
+func applyEnv(v reflect.Value) ([]string, error) {
+	var applied []string
+	err := walkFields(v, "", func(path string, _ reflect.StructField, fv reflect.Value) error {
+		value, ok, err := lookupEnv(path)
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := setField(fv, value); err != nil {
+			return fmt.Errorf("%s: %w", envName(path), err)
+		}
+		applied = append(applied, path)
+		return nil
+	})
+
+	return applied, err
+}
+
+func setField(fv reflect.Value, value string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", fv.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("GS_PLAIN", "value")
+	t.Setenv("GS_COMMENT", "s3cr #t")
+	t.Setenv("GS_COLON", "abc: def")
+	t.Setenv("GS_QUOTE", `"quoted`)
+	t.Setenv("GS_NUMBER", "0123")
+	t.Setenv("GS_NEWLINE", "a\nb")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "key: ${GS_PLAIN}", "value"},
+		{"embedded", "key: pre-${GS_PLAIN}-post", "pre-value-post"},
+		{"comment char", "key: ${GS_COMMENT}", "s3cr #t"},
+		{"colon", "key: ${GS_COLON}", "abc: def"},
+		{"quote", "key: ${GS_QUOTE}", `"quoted`},
+		{"number stays string", "key: ${GS_NUMBER}", "0123"},
+		{"newline", "key: ${GS_NEWLINE}", "a\nb"},
+		{"quoted in file", `key: "${GS_COMMENT}"`, "s3cr #t"},
+		{"unset", "key: ${GS_UNSET_VARIABLE}", ""},
+		{"bare dollar", "key: pa$$word", "pa$$word"},
+		{"comment ignored", "key: x # ${GS_PLAIN}", "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := interpolateEnv([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("interpolateEnv() error = %v", err)
+			}
+
+			var got map[string]string
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("parsing %q: %v", out, err)
+			}
+			if got["key"] != tt.want {
+				t.Errorf("key = %q, want %q", got["key"], tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateEnvNested(t *testing.T) {
+	t.Setenv("GS_DIR", "/data #1")
+	t.Setenv("GS_KEY", "key")
+
+	out, err := interpolateEnv([]byte("backup:\n  dirs:\n    - ${GS_DIR}\n    - /other\n${GS_KEY}: ${GS_KEY}\n"))
+	if err != nil {
+		t.Fatalf("interpolateEnv() error = %v", err)
+	}
+
+	var got struct {
+		Backup struct {
+			Dirs []string `yaml:"dirs"`
+		} `yaml:"backup"`
+		Keys map[string]string `yaml:",inline"`
+	}
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("parsing %q: %v", out, err)
+	}
+	if len(got.Backup.Dirs) != 2 || got.Backup.Dirs[0] != "/data #1" {
+		t.Errorf("dirs = %q, want [/data #1 /other]", got.Backup.Dirs)
+	}
+	if got.Keys["${GS_KEY}"] != "key" {
+		t.Errorf("keys = %v, want only the value interpolated", got.Keys)
+	}
+}
+
+func TestInterpolateEnvInvalid(t *testing.T) {
+	if _, err := interpolateEnv([]byte("key: [unterminated")); err == nil {
+		t.Error("interpolateEnv() error = nil, want parse error")
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	commonLogger "github.com/hibare/GoCommon/v2/pkg/logger"
+)
+
+// logOutput is where logs are written, set by LoadConfig and kept across
+// reloads applied with Apply.
+var logOutput io.Writer = os.Stdout
+
+// initLogger sets up the default logger to write to logOutput at level in
+// mode, e.g. INFO & PRETTY. Unknown values fall back to INFO & JSON like the
+// common logger does.
+func initLogger(level, mode string) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{AddSource: true, Level: l}
+	if strings.ToUpper(mode) == commonLogger.LogModePretty {
+		slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, opts)))
+		return
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, opts)))
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported config version")
+
+// migration upgrades a config document from version From to From+1. The
+// version key is updated after Apply succeeds.
+type migration struct {
+	From        int
+	Description string
+	Apply       func(root *yaml.Node) error
+}
+
+// migrations are applied in order, index i upgrades version i. Files without
+// a version key are version 0.
+var migrations = []migration{
+	{
+		From:        0,
+		Description: "add schema version, the layout is unchanged",
+		Apply:       func(*yaml.Node) error { return nil },
+	},
+	{
+		From:        1,
+		Description: "move backup.retention-count to backup.retention.count",
+		Apply:       moveRetentionCount,
+	},
+}
+
+// MigrationResult describes an upgrade of the config file.
+type MigrationResult struct {
+	From    int
+	To      int
+	Applied []string
+	Data    []byte
+	Backup  string
+}
+
+// mappingIndex returns the index of key in the content of the mapping node
+// n, or -1 if it is missing.
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in the mapping node n.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(n, key); i >= 0 {
+		return n.Content[i+1]
+	}
+	return nil
+}
+
+// moveRetentionCount replaces backup.retention-count with a retention block
+// holding the count. The key keeps its position and comments.
+func moveRetentionCount(root *yaml.Node) error {
+	backup := mappingValue(root, "backup")
+	if backup == nil || backup.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	i := mappingIndex(backup, "retention-count")
+	if i < 0 {
+		return nil
+	}
+	if mappingIndex(backup, "retention") >= 0 {
+		return errors.New("backup.retention-count and backup.retention are both set")
+	}
+
+	key, value := backup.Content[i], backup.Content[i+1]
+	key.Value = "retention"
+	backup.Content[i+1] = &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "count"},
+			value,
+		},
+	}
+	return nil
+}
+
+func docVersion(root *yaml.Node) (int, error) {
+	value := mappingValue(root, "version")
+	if value == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(value.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, value.Value)
+	}
+	return version, nil
+}
+
+// setDocVersion sets the version key of root, adding it at the top if missing.
+func setDocVersion(root *yaml.Node, version int) {
+	if value := mappingValue(root, "version"); value != nil {
+		value.SetString(strconv.Itoa(version))
+		value.Tag = "!!int"
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// upgrade applies the pending migrations to the config file data. Comments
+// and ${VAR} references are kept as is.
+func upgrade(data []byte) (*MigrationResult, error) {
+	result := &MigrationResult{To: constants.ConfigVersion, Data: data}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		result.From = constants.ConfigVersion
+		return result, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("config file is not a yaml mapping")
+	}
+	root := doc.Content[0]
+
+	version, err := docVersion(root)
+	if err != nil {
+		return nil, err
+	}
+	if version < 0 || version > constants.ConfigVersion {
+		return nil, fmt.Errorf("%w: %d, this build supports up to %d", ErrUnsupportedVersion, version, constants.ConfigVersion)
+	}
+	result.From = version
+
+	if version == constants.ConfigVersion {
+		return result, nil
+	}
+
+	for _, m := range migrations[version:] {
+		if err := m.Apply(root); err != nil {
+			return nil, fmt.Errorf("migrating config from version %d: %w", m.From, err)
+		}
+		setDocVersion(root, m.From+1)
+		result.Applied = append(result.Applied, fmt.Sprintf("v%d -> v%d: %s", m.From, m.From+1, m.Description))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	result.Data = buf.Bytes()
+
+	return result, nil
+}
+
+// Migrate upgrades the config file to the current schema version. The file
+// is only rewritten if write is set, the previous content is kept in a
+// backup copy next to it.
+func Migrate(write bool) (*MigrationResult, error) {
+	data, err := os.ReadFile(BC.ConfigFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := upgrade(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !write || result.From == result.To {
+		return result, nil
+	}
+
+	result.Backup = fmt.Sprintf("%s.v%d-%s.bak", BC.ConfigFilePath, result.From, time.Now().Format(constants.DefaultDateTimeLayout))
+	if err := writeFile(result.Backup, data); err != nil {
+		return nil, fmt.Errorf("writing backup copy: %w", err)
+	}
+
+	if err := writeFile(BC.ConfigFilePath, result.Data); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"gopkg.in/yaml.v3"
+)
+
+func TestUpgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		wantFrom    int
+		wantApplied int
+		wantErr     error
+		wantData    []string
+	}{
+		{"empty", "", constants.ConfigVersion, 0, nil, nil},
+		{"unversioned", "# my backups\ns3:\n  secret-key: ${SECRET}\n", 0, 2, nil, []string{"version: 2", "# my backups", "${SECRET}"}},
+		{"version 1", "version: 1\nbackup:\n  # keep a month\n  retention-count: 30\n", 1, 1, nil, []string{"version: 2", "# keep a month\n  retention:\n    count: 30\n"}},
+		{"current", "version: 2\ns3:\n  bucket: b\n", 2, 0, nil, []string{"version: 2\ns3:\n  bucket: b\n"}},
+		{"too new", "version: 99\n", 0, 0, ErrUnsupportedVersion, nil},
+		{"negative", "version: -1\n", 0, 0, ErrUnsupportedVersion, nil},
+		{"not a number", "version: one\n", 0, 0, ErrUnsupportedVersion, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := upgrade([]byte(tt.in))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("upgrade() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if result.From != tt.wantFrom || result.To != constants.ConfigVersion || len(result.Applied) != tt.wantApplied {
+				t.Errorf("upgrade() = from %d to %d applied %q, want from %d applied %d", result.From, result.To, result.Applied, tt.wantFrom, tt.wantApplied)
+			}
+			for _, want := range tt.wantData {
+				if !strings.Contains(string(result.Data), want) {
+					t.Errorf("upgrade() data %q, want it to contain %q", result.Data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpgradeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"not a mapping", "- a\n- b\n"},
+		{"retention set twice", "version: 1\nbackup:\n  retention-count: 3\n  retention:\n    count: 4\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := upgrade([]byte(tt.in)); err == nil {
+				t.Error("upgrade() error = nil, want error")
+			}
+		})
+	}
+}
+
+// oldLayout is a config file as written before schema versions existed.
+const oldLayout = `s3:
+  bucket: backups
+  prefix: hosts
+backup:
+  dirs:
+    - /data
+  # keep two weeks
+  retention-count: 14
+  cron: "0 2 * * *"
+`
+
+func TestUpgradeLayout(t *testing.T) {
+	result, err := upgrade([]byte(oldLayout))
+	if err != nil {
+		t.Fatalf("upgrade() error = %v", err)
+	}
+
+	var got map[string]any
+	if err := yaml.Unmarshal(result.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	backup := got["backup"].(map[string]any)
+
+	if got["version"] != constants.ConfigVersion {
+		t.Errorf("version = %v, want %d", got["version"], constants.ConfigVersion)
+	}
+	if _, ok := backup["retention-count"]; ok {
+		t.Error("backup.retention-count is still set")
+	}
+	if retention, ok := backup["retention"].(map[string]any); !ok || retention["count"] != 14 {
+		t.Errorf("backup.retention = %v, want count 14", backup["retention"])
+	}
+	if backup["cron"] != "0 2 * * *" || got["s3"].(map[string]any)["bucket"] != "backups" {
+		t.Errorf("other keys changed: %v", got)
+	}
+	if !strings.Contains(string(result.Data), "# keep two weeks") {
+		t.Errorf("comment was dropped: %s", result.Data)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	previous := BC.ConfigFilePath
+	t.Cleanup(func() { BC.ConfigFilePath = previous })
+	BC.ConfigFilePath = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(BC.ConfigFilePath, []byte(oldLayout), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Migrate(true)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if result.From != 0 || result.To != constants.ConfigVersion {
+		t.Errorf("Migrate() = from %d to %d, want from 0 to %d", result.From, result.To, constants.ConfigVersion)
+	}
+
+	if backup, err := os.ReadFile(result.Backup); err != nil || string(backup) != oldLayout {
+		t.Errorf("backup copy = %q, %v, want the old file", backup, err)
+	}
+
+	c, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	if c.Version != constants.ConfigVersion || c.Backup.Retention.Count != 14 || c.Backup.Dirs[0] != "/data" {
+		t.Errorf("ReadConfig() = version %d retention %d dirs %v, want %d, 14, [/data]", c.Version, c.Backup.Retention.Count, c.Backup.Dirs, constants.ConfigVersion)
+	}
+
+	again, err := Migrate(true)
+	if err != nil || again.From != constants.ConfigVersion || again.Backup != "" {
+		t.Errorf("second Migrate() = %+v, %v, want no change", again, err)
+	}
+}
+
+func TestReadConfigOldLayout(t *testing.T) {
+	previous := BC.ConfigFilePath
+	t.Cleanup(func() { BC.ConfigFilePath = previous })
+	BC.ConfigFilePath = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(BC.ConfigFilePath, []byte(oldLayout), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ReadConfig()
+	if err != nil {
+		t.Fatalf("ReadConfig() error = %v", err)
+	}
+	if c.Backup.Retention.Count != 14 {
+		t.Errorf("retention count = %d, want 14 from the old layout", c.Backup.Retention.Count)
+	}
+	if data, _ := os.ReadFile(BC.ConfigFilePath); string(data) != oldLayout {
+		t.Error("ReadConfig() rewrote the config file")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "config.yaml")
+	link := filepath.Join(dir, "link.yaml")
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFile(link, []byte("new")); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("target = %q, want new", data)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced: %v, %v", info, err)
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("dir has %d entries, want no temporary files left", len(entries))
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Value sources.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+const redacted = "********"
+
+var ErrUnknownFormat = errors.New("unknown format")
+
+func resolveSources(c *Config, v *viper.Viper, overridden []string) map[string]string {
+	m := map[string]string{}
+	_ = walkFields(reflect.ValueOf(c).Elem(), "", func(path string, _ reflect.StructField, _ reflect.Value) error {
+		switch {
+		case slices.Contains(overridden, path):
+			m[path] = SourceEnv
+		case v.IsSet(path):
+			m[path] = SourceFile
+		default:
+			m[path] = SourceDefault
+		}
+		return nil
+	})
+	return m
+}
+
+// secretPaths returns the yaml paths of fields tagged secret:"true".
+func secretPaths() []string {
+	var paths []string
+	_ = walkFields(reflect.ValueOf(Config{}), "", func(path string, field reflect.StructField, _ reflect.Value) error {
+		if field.Tag.Get("secret") == "true" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths
+}
+
+// Show renders the effective config as yaml or json. In yaml each value is
+// annotated with its source, in json the sources are listed alongside the
+// config. Secret values are masked unless reveal is set.
+func Show(format string, reveal bool) ([]byte, error) {
+	c := Get()
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return nil, err
+	}
+
+	var secrets []string
+	if !reveal {
+		secrets = secretPaths()
+	}
+	annotate(&node, secrets, c.sources)
+
+	switch format {
+	case constants.FormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case constants.FormatJSON:
+		var config any
+		if err := node.Decode(&config); err != nil {
+			return nil, err
+		}
+		return json.MarshalIndent(map[string]any{
+			"config":  config,
+			"sources": c.sources,
+		}, "", "  ")
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// walkNodes calls fn for every key & value pair of the mapping nodes in n
+// with the yaml path of the key.
+func walkNodes(n *yaml.Node, prefix string, fn func(path string, key, value *yaml.Node)) {
+	if n.Kind == yaml.DocumentNode {
+		for _, c := range n.Content {
+			walkNodes(c, prefix, fn)
+		}
+		return
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		fn(path, key, value)
+		walkNodes(value, path, fn)
+	}
+}
+
+// annotate comments the values in n with their source in sources and masks
+// the values at secrets.
+func annotate(n *yaml.Node, secrets []string, sources map[string]string) {
+	walkNodes(n, "", func(path string, key, value *yaml.Node) {
+		if slices.Contains(secrets, path) && value.Kind == yaml.ScalarNode && value.Value != "" {
+			value.Value = redacted
+			value.Tag = "!!str"
+			value.Style = 0
+		}
+
+		src, ok := sources[path]
+		if !ok {
+			return
+		}
+		if value.Kind == yaml.ScalarNode {
+			value.LineComment = src
+		} else {
+			key.LineComment = src
+		}
+	})
+}
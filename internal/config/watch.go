@@ -0,0 +1,114 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+// watchedFile follows the config file through symlinks, e.g. those of a
+// Kubernetes ConfigMap whose data dir link is swapped on update.
+type watchedFile struct {
+	path     string
+	resolved string
+}
+
+func newWatchedFile(path string) *watchedFile {
+	path = filepath.Clean(path)
+	return &watchedFile{path: path, resolved: resolvePath(path)}
+}
+
+// resolvePath returns path with symlinks followed, or path if it can't be
+// resolved, e.g. while it is being replaced.
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// dirs returns the dirs to watch for changes to the file.
+func (f *watchedFile) dirs() []string {
+	dirs := []string{filepath.Dir(f.path)}
+	if dir := filepath.Dir(f.resolved); dir != dirs[0] {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// changed reports whether an event on name changed the file, either the
+// file itself or a link on the way to it.
+func (f *watchedFile) changed(name string) bool {
+	name = filepath.Clean(name)
+	resolved := resolvePath(f.path)
+	swapped := resolved != f.resolved
+	f.resolved = resolved
+	return swapped || name == f.path || name == resolved
+}
+
+// Watch calls onChange when the config file changes or SIGHUP is received,
+// until ctx is done. File changes are debounced as editors write in steps.
+func Watch(ctx context.Context, onChange func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Watch the dirs as editors & config management replace the file or
+	// swap a link to it
+	file := newWatchedFile(BC.ConfigFilePath)
+	for _, dir := range file.dirs() {
+		if err := w.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading config")
+			onChange()
+
+		case event, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) || !file.changed(event.Name) {
+				continue
+			}
+			for _, dir := range file.dirs() {
+				if err := w.Add(dir); err != nil {
+					slog.Warn("Error watching config dir", "dir", dir, "error", err)
+				}
+			}
+			debounce = time.After(constants.ConfigReloadDebounce)
+
+		case <-debounce:
+			debounce = nil
+			slog.Info("Config file changed, reloading config")
+			onChange()
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Error("Error watching config file", "error", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWatchedFile(t *testing.T) {
+	write := func(t *testing.T, name string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("version: 2\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(t *testing.T, target, name string) {
+		t.Helper()
+		if err := os.Symlink(target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("plain file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.yaml")
+		write(t, path)
+
+		f := newWatchedFile(path)
+		if f.changed(filepath.Join(dir, "history.jsonl")) {
+			t.Error("changed() = true for another file in the dir")
+		}
+		if !f.changed(path) {
+			t.Error("changed() = false for the config file")
+		}
+	})
+
+	t.Run("configmap swap", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.yaml")
+		write(t, filepath.Join(dir, "..v1", "config.yaml"))
+		link(t, "..v1", filepath.Join(dir, "..data"))
+		link(t, filepath.Join("..data", "config.yaml"), path)
+
+		f := newWatchedFile(path)
+
+		// The new data dir is written next to the old one first
+		write(t, filepath.Join(dir, "..v2", "config.yaml"))
+		link(t, "..v2", filepath.Join(dir, "..data_tmp"))
+		if f.changed(filepath.Join(dir, "..data_tmp")) {
+			t.Error("changed() = true before the swap")
+		}
+
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+		if !f.changed(filepath.Join(dir, "..data")) {
+			t.Error("changed() = false after the data link was swapped")
+		}
+		if f.changed(filepath.Join(dir, "..v1")) {
+			t.Error("changed() = true when the old data dir is removed")
+		}
+	})
+
+	t.Run("link to another dir", func(t *testing.T) {
+		dir, other := t.TempDir(), t.TempDir()
+		path := filepath.Join(dir, "config.yaml")
+		target := filepath.Join(other, "gos3backup.yaml")
+		write(t, target)
+		link(t, target, path)
+
+		f := newWatchedFile(path)
+		if dirs := f.dirs(); !slices.Contains(dirs, dir) || !slices.Contains(dirs, other) {
+			t.Errorf("dirs() = %v, want %s and %s", dirs, dir, other)
+		}
+		if !f.changed(target) {
+			t.Error("changed() = false for the link target")
+		}
+	})
+}
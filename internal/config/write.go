@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"gopkg.in/yaml.v3"
+)
+
+// fieldComments documents the keys in config files written by WriteConfig.
+var fieldComments = map[string]string{
+	"version":                           "Config schema version, upgrade older files with `config migrate --write`",
+	"s3":                                "S3 compatible storage the backups are uploaded to",
+	"s3.endpoint":                       "Leave empty for AWS S3",
+	"s3.access-key":                     "Static credentials, used with auth type static",
+	"s3.prefix":                         "Backups are stored under <prefix>/<hostname>/<timestamp>/",
+	"s3.auth":                           "Credentials: static, default, profile, imds, web-identity or assume-role",
+	"backup":                            "What to back up and when",
+	"backup.dirs":                       "Directories to back up",
+	"backup.retention":                  "Retention of backups",
+	"backup.retention.count":            "Number of backups to keep, older ones are purged",
+	"backup.date-time-layout":           "Go time layout of backup timestamps",
+	"backup.cron":                       "Schedule in cron format, evaluated in backup.timezone",
+	"backup.archive-dirs":               "Upload each dir as a zip archive instead of individual files",
+	"backup.encryption":                 "GPG encryption of archives, requires archive-dirs",
+	"backup.lock-ttl":                   "Expiry of the lock held in the bucket while a run is active",
+	"backup.shutdown-grace":             "Time given to the current dir to finish on shutdown",
+	"backup.run-on-start":               "Run a backup whenever the daemon starts",
+	"backup.catch-up":                   "Run a backup at daemon start if a scheduled run was missed",
+	"backup.jitter":                     "Random delay before scheduled runs to spread load",
+	"backup.jitter-per-host":            "Derive the jitter delay from the hostname so it is stable",
+	"backup.anomaly":                    "Ransomware guard, marks a run suspicious and skips purge if too many files changed",
+	"backup.anomaly.min-files":          "Minimum number of files in the previous snapshot to compare with",
+	"backup.anomaly.max-modified-ratio": "Fraction of files modified or removed since the previous snapshot",
+	"backup.anomaly.max-entropy":        "Average entropy of changed files in bits per byte, encrypted data is close to 8",
+	"backup.anomaly.max-size-change":    "Fraction by which the total size may grow or shrink",
+	"backup.anomaly.min-changed-files":  "Minimum number of modified or added files before their entropy is checked",
+	"backup.object-lock":                "S3 Object Lock on uploads, retained until purged under retention.count",
+	"backup.object-lock.mode":           "Retention mode, governance or compliance which not even the root account can lift",
+	"backup.object-lock.legal-hold":     "Also place a legal hold, objects are kept until it is removed",
+	"notifiers":                         "Notifications, mode is per-dir, summary or failures-only",
+	"heartbeat":                         "Dead man's switch pings, type is healthchecks or uptime-kuma",
+	"metrics":                           "Prometheus metrics served in daemon mode",
+	"api":                               "HTTP control API served in daemon mode, requires a token",
+	"logger":                            "Log level and mode",
+	"notifiers.discord":                 "Discord webhook notifications",
+	"notifiers.discord.webhook":         "Webhook URL, Discord is disabled if empty",
+}
+
+// DefaultConfig returns a config with the defaults LoadConfig would apply.
+func DefaultConfig() *Config {
+	return &Config{
+		Version: constants.ConfigVersion,
+		Backup: BackupConfig{
+			Retention:      RetentionConfig{Count: constants.DefaultRetentionCount},
+			DateTimeLayout: constants.DefaultDateTimeLayout,
+			Cron:           constants.DefaultCron,
+			Timezone:       constants.DefaultTimezone,
+			Anomaly: AnomalyConfig{
+				MinFiles:         constants.DefaultAnomalyMinFiles,
+				MaxModifiedRatio: constants.DefaultAnomalyMaxModifiedRatio,
+				MaxEntropy:       constants.DefaultAnomalyMaxEntropy,
+				MaxSizeChange:    constants.DefaultAnomalyMaxSizeChange,
+				MinChangedFiles:  constants.DefaultAnomalyMinChangedFiles,
+			},
+			ObjectLock: ObjectLockConfig{Mode: constants.DefaultObjectLockMode},
+		},
+		S3: S3Config{
+			Auth: S3AuthConfig{Type: constants.DefaultS3AuthType},
+		},
+		Notifiers: NotifiersConfig{Mode: constants.DefaultNotifierMode},
+		Heartbeat: HeartbeatConfig{Type: constants.DefaultHeartbeatType},
+		Metrics:   MetricsConfig{Listen: constants.DefaultMetricsListen},
+		API:       APIConfig{Listen: constants.DefaultAPIListen},
+	}
+}
+
+// WriteConfig writes c to the config file as commented yaml. The file is
+// only readable by its owner as it may contain credentials.
+func WriteConfig(c *Config) error {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return err
+	}
+
+	walkNodes(&node, "", func(path string, key, _ *yaml.Node) {
+		if comment, ok := fieldComments[path]; ok {
+			key.HeadComment = comment
+		}
+	})
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+
+	if err := BC.EnsureConfigRootDir(); err != nil {
+		return err
+	}
+
+	return writeFile(BC.ConfigFilePath, buf.Bytes())
+}
+
+// writeFile replaces the file name with data through a synced temporary
+// file in the same dir, so that a crash leaves either the old or the new
+// content. Symlinks are followed and the file is only readable by the owner.
+func writeFile(name string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+
+	// Sync the dir so that the rename survives a crash
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
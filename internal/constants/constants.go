@@ -1,11 +1,99 @@
 package constants
 
+import "time"
+
 const (
 	ProgramIdentifier     = "GoS3Backup"
+	EnvPrefix             = "GOS3BACKUP"
 	DefaultDateTimeLayout = "20060102150405"
 	DefaultRetentionCount = 30
 	DefaultCron           = "0 0 * * *"
 	VersionCheckCron      = "0 0 * * *"
 	NotAvailable          = "N/A"
 	GithubOwner           = "hibare"
+	HistoryFileName       = "history.jsonl"
+	HistoryMaxRuns        = 1000
+	IndexDirName          = "index"
+	LockFileName          = ".gos3backup.lock"
+	DefaultLockTTL        = 15 * time.Minute
+	InterruptedFileName   = "interrupted.json"
+	DefaultShutdownGrace  = 30 * time.Second
+	AbortUploadsTimeout   = 30 * time.Second
+	DefaultTimezone       = "UTC"
+	ProbeObjectPrefix     = ".gos3backup-probe-"
+	ConfigReloadDebounce  = time.Second
+	ConfigVersion         = 2
+	ManifestFileName      = ".manifest.json"
+	SuspiciousFileName    = ".suspicious.json"
+)
+
+const (
+	DefaultAnomalyMinFiles         = 10
+	DefaultAnomalyMaxModifiedRatio = 0.5
+	DefaultAnomalyMaxEntropy       = 7.5
+	DefaultAnomalyMaxSizeChange    = 0.5
+	DefaultAnomalyMinChangedFiles  = 10
+	AnomalyEntropySampleFiles      = 100
+	AnomalyEntropySampleBytes      = 64 * 1024
+)
+
+const (
+	MountFsName              = "gos3backup"
+	MountCacheBlockSize      = 1024 * 1024
+	MountAttrTimeout         = time.Minute
+	DefaultMountCacheSizeMiB = 1024
+)
+
+const (
+	NotifierModePerDir       = "per-dir"
+	NotifierModeSummary      = "summary"
+	NotifierModeFailuresOnly = "failures-only"
+	DefaultNotifierMode      = NotifierModePerDir
+)
+
+var NotifierModes = []string{NotifierModePerDir, NotifierModeSummary, NotifierModeFailuresOnly}
+
+const (
+	HeartbeatTypeHealthchecks = "healthchecks"
+	HeartbeatTypeUptimeKuma   = "uptime-kuma"
+	DefaultHeartbeatType      = HeartbeatTypeHealthchecks
+	HeartbeatTimeout          = 10 * time.Second
+)
+
+var HeartbeatTypes = []string{HeartbeatTypeHealthchecks, HeartbeatTypeUptimeKuma}
+
+const (
+	ObjectLockGovernance  = "governance"
+	ObjectLockCompliance  = "compliance"
+	DefaultObjectLockMode = ObjectLockGovernance
+)
+
+var ObjectLockModes = []string{ObjectLockGovernance, ObjectLockCompliance}
+
+const (
+	DefaultMetricsListen = "127.0.0.1:9477"
+	MetricsPath          = "/metrics"
+	DefaultAPIListen     = "127.0.0.1:9478"
 )
+
+const (
+	S3AuthStatic           = "static"
+	S3AuthDefault          = "default"
+	S3AuthProfile          = "profile"
+	S3AuthIMDS             = "imds"
+	S3AuthWebIdentity      = "web-identity"
+	S3AuthAssumeRole       = "assume-role"
+	DefaultS3AuthType      = S3AuthStatic
+	DefaultRoleSessionName = "gos3backup"
+)
+
+var S3AuthTypes = []string{S3AuthStatic, S3AuthDefault, S3AuthProfile, S3AuthIMDS, S3AuthWebIdentity, S3AuthAssumeRole}
+
+const (
+	FormatYAML  = "yaml"
+	FormatJSON  = "json"
+	FormatTable = "table"
+	FormatCSV   = "csv"
+)
+
+var OutputFormats = []string{FormatTable, FormatJSON, FormatYAML, FormatCSV}
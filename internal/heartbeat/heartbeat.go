@@ -0,0 +1,128 @@
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+var (
+	ErrHeartbeatDisabled = errors.New("heartbeat is disabled")
+	ErrUnexpectedStatus  = errors.New("unexpected status code")
+)
+
+var client = &http.Client{Timeout: constants.HeartbeatTimeout}
+
+func runPreChecks() error {
+	if !config.Get().Heartbeat.Enabled {
+		return ErrHeartbeatDisabled
+	}
+	return nil
+}
+
+// Start pings the monitor when a backup job starts. Uptime Kuma has no notion
+// of a started job, so nothing is sent for it.
+func Start() {
+	if err := runPreChecks(); err != nil {
+		slog.Debug("skipping heartbeat", "error", err)
+		return
+	}
+
+	if config.Get().Heartbeat.Type == constants.HeartbeatTypeUptimeKuma {
+		return
+	}
+
+	if err := ping(http.MethodPost, joinURL(config.Get().Heartbeat.URL, "start"), ""); err != nil {
+		slog.Error("error sending start heartbeat", "error", err)
+	}
+}
+
+// Success pings the monitor when a backup job completes successfully.
+func Success(duration time.Duration) {
+	if err := runPreChecks(); err != nil {
+		slog.Debug("skipping heartbeat", "error", err)
+		return
+	}
+
+	var err error
+	switch config.Get().Heartbeat.Type {
+	case constants.HeartbeatTypeUptimeKuma:
+		err = ping(http.MethodGet, kumaURL("up", "OK", duration), "")
+	default:
+		err = ping(http.MethodPost, config.Get().Heartbeat.URL, fmt.Sprintf("Backup completed in %s", duration))
+	}
+
+	if err != nil {
+		slog.Error("error sending success heartbeat", "error", err)
+	}
+}
+
+// Failure pings the monitor when a backup job fails, passing on the error.
+func Failure(duration time.Duration, jobErr error) {
+	if err := runPreChecks(); err != nil {
+		slog.Debug("skipping heartbeat", "error", err)
+		return
+	}
+
+	var err error
+	switch config.Get().Heartbeat.Type {
+	case constants.HeartbeatTypeUptimeKuma:
+		err = ping(http.MethodGet, kumaURL("down", jobErr.Error(), duration), "")
+	default:
+		err = ping(http.MethodPost, joinURL(config.Get().Heartbeat.URL, "fail"), jobErr.Error())
+	}
+
+	if err != nil {
+		slog.Error("error sending failure heartbeat", "error", err)
+	}
+}
+
+// joinURL appends a path segment to a healthchecks ping URL.
+func joinURL(base, segment string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), segment)
+}
+
+// kumaURL builds an Uptime Kuma push URL with status, message and ping time.
+func kumaURL(status, msg string, duration time.Duration) string {
+	u, err := url.Parse(config.Get().Heartbeat.URL)
+	if err != nil {
+		return config.Get().Heartbeat.URL
+	}
+
+	q := u.Query()
+	q.Set("status", status)
+	q.Set("msg", msg)
+	q.Set("ping", strconv.FormatInt(duration.Milliseconds(), 10))
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func ping(method, target, body string) error {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", constants.ProgramIdentifier)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gos3backup"
+
+// Outcome label values.
+const (
+	OutcomeSuccess     = "success"
+	OutcomeFailure     = "failure"
+	OutcomeInterrupted = "interrupted"
+	OutcomeLocked      = "locked"
+	OutcomeSkipped     = "skipped"
+)
+
+// Stage label values for StageDuration.
+const (
+	StageArchive = "archive"
+	StageEncrypt = "encrypt"
+	StageUpload  = "upload"
+)
+
+var (
+	RunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Number of backup runs by outcome.",
+	}, []string{"outcome"})
+
+	LastSuccessTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful backup of a directory.",
+	}, []string{"dir"})
+
+	UploadedBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Number of bytes uploaded to S3.",
+	}, []string{"dir"})
+
+	FilesProcessedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_processed_total",
+		Help:      "Number of files successfully backed up.",
+	}, []string{"dir"})
+
+	FilesFailedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "files_failed_total",
+		Help:      "Number of files that could not be backed up.",
+	}, []string{"dir"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of the archive, encrypt and upload stages.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+	}, []string{"stage"})
+
+	SnapshotsStored = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "snapshots_stored",
+		Help:      "Number of snapshots stored in the bucket for this host.",
+	})
+
+	AnomaliesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "anomalies_total",
+		Help:      "Number of runs marked suspicious by the anomaly guard.",
+	})
+
+	PurgeDeletionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purge_deletions_total",
+		Help:      "Number of snapshots deleted by purge, by outcome.",
+	}, []string{"outcome"})
+
+	NotifierFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifier_failures_total",
+		Help:      "Number of notifications that failed to send.",
+	}, []string{"notifier"})
+)
+
+// Serve exposes the metrics on addr under constants.MetricsPath. It blocks
+// until ctx is done or the listener fails.
+func Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(constants.MetricsPath, promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: commonHttp.DefaultServerReadTimeout,
+		ReadTimeout:       commonHttp.DefaultServerReadTimeout,
+		WriteTimeout:      commonHttp.DefaultServerWriteTimeout,
+		IdleTimeout:       commonHttp.DefaultServerIdleTimeout,
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Error("Error shutting down metrics", "error", err)
+		}
+	})
+	defer stop()
+
+	slog.Info("Serving metrics", "addr", addr, "path", constants.MetricsPath)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -4,148 +4,263 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 
 	"github.com/hibare/GoCommon/v2/pkg/notifiers/discord"
 	"github.com/hibare/GoS3Backup/internal/config"
 	"github.com/hibare/GoS3Backup/internal/constants"
-	"github.com/hibare/GoS3Backup/internal/version"
+	"github.com/hibare/GoS3Backup/internal/metrics"
 )
 
+const discordUpdateFooter = "{{ if .Version.UpdateAvailable }}{{ .Version.UpdateNotification }}{{ end }}"
+
+const discordLastSuccess = "{{ if .LastSuccess.IsZero }}Never{{ else }}{{ humanizeDuration (since .LastSuccess) }} ago{{ end }}"
+
+const discordPurgeOutcome = "Deleted {{ len .Deleted }}, remaining {{ .Remaining }}{{ if lt .Remaining .Expected }} (expected {{ .Expected }}){{ end }}{{ with .Failed }}, failed {{ len . }}{{ end }}{{ with .Locked }}, locked {{ len . }}{{ end }}"
+
+const discordSummaryTable = "```\n" +
+	"{{ printf \"%-6s  %-32s  %11s  %10s\" \"STATUS\" \"DIRECTORY\" \"FILES\" \"SIZE\" }}\n" +
+	"{{ range .Dirs }}{{ if .Error }}FAILED{{ else }}OK    {{ end }}  " +
+	"{{ printf \"%-32s  %11s  %10s\" .Directory (printf \"%d/%d\" .SuccessFiles .TotalFiles) (humanizeBytes .Size) }}\n" +
+	"{{ end }}```"
+
+// defaultDiscordTemplates are used for any event or template part not
+// overridden in notifiers.discord.templates.
+var defaultDiscordTemplates = map[string]config.DiscordTemplateConfig{
+	EventBackupSuccess: {
+		Content:     "**Backup Successful** - *{{ .Hostname }}*",
+		Title:       "Directory",
+		Description: "{{ .Directory }}",
+		Color:       "1498748",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Key", Value: "{{ .Key }}"},
+			{Name: "Dirs", Value: "{{ .TotalDirs }}", Inline: true},
+			{Name: "Files", Value: "{{ .SuccessFiles }}/{{ .TotalFiles }}", Inline: true},
+		},
+	},
+	EventBackupFailure: {
+		Content:     "**Backup Failed** - *{{ .Hostname }}*",
+		Title:       "Error",
+		Description: "{{ .Error }}",
+		Color:       "14554702",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Directory", Value: "{{ .Directory }}"},
+			{Name: "Dirs", Value: "{{ .TotalDirs }}", Inline: true},
+			{Name: "Files", Value: "{{ .TotalFiles }}", Inline: true},
+			{Name: "Last Success", Value: discordLastSuccess, Inline: true},
+		},
+	},
+	EventBackupDeleteFailure: {
+		Content:     "**Backup Deletion Failed** - *{{ .Hostname }}*",
+		Title:       "Error",
+		Description: "{{ .Error }}",
+		Color:       "14590998",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Key", Value: "{{ .Key }}"},
+		},
+	},
+	EventPurgeSuccess: {
+		Content:     "**Backup Purge Successful** - *{{ .Hostname }}*",
+		Title:       "Deleted",
+		Description: "{{ range .Deleted }}{{ . }}\n{{ end }}",
+		Color:       "1498748",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Deleted", Value: "{{ len .Deleted }}", Inline: true},
+			{Name: "Remaining", Value: "{{ .Remaining }}", Inline: true},
+			{Name: "Retention", Value: "{{ .Retention }}", Inline: true},
+		},
+	},
+	EventRetentionWarning: {
+		Content:     "**Backups Below Retention** - *{{ .Hostname }}*",
+		Title:       "Warning",
+		Description: "Found {{ .Remaining }} backups, expected {{ .Expected }}. Backups may have stopped or been deleted.",
+		Color:       "16753920",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Remaining", Value: "{{ .Remaining }}", Inline: true},
+			{Name: "Expected", Value: "{{ .Expected }}", Inline: true},
+			{Name: "Retention", Value: "{{ .Retention }}", Inline: true},
+		},
+	},
+	EventConfigReloadFailure: {
+		Content:     "**Config Reload Failed** - *{{ .Hostname }}*",
+		Title:       "Error",
+		Description: "{{ .Error }}",
+		Color:       "14554702",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Config", Value: "{{ .Key }}"},
+			{Name: "Status", Value: "Previous config is still in use"},
+		},
+	},
+	EventAnomalyDetected: {
+		Content:     "@everyone **Suspicious Backup** - *{{ .Hostname }}*",
+		Title:       "Anomaly Detected",
+		Description: "{{ range .Reasons }}- {{ . }}\n{{ end }}",
+		Color:       "14554702",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Snapshot", Value: "{{ .Key }}"},
+			{Name: "Compared With", Value: "{{ .Previous }}"},
+			{Name: "Files", Value: "{{ .Modified }} modified, {{ .Removed }} removed, {{ .Added }} added, {{ .Unchanged }} unchanged"},
+			{Name: "Status", Value: "Snapshot marked suspicious, purge skipped"},
+		},
+	},
+	EventRunSummary: {
+		Content:     "**Backup {{ if .Error }}Failed{{ else if .Failed }}Completed With Errors{{ else }}Successful{{ end }}** - *{{ .Hostname }}*",
+		Title:       "Summary",
+		Description: "{{ with .Error }}{{ . }}\n{{ end }}" + discordSummaryTable,
+		Color:       "{{ if .Failed }}14554702{{ else }}1498748{{ end }}",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Dirs", Value: "{{ .Succeeded }}/{{ len .Dirs }} succeeded", Inline: true},
+			{Name: "Duration", Value: "{{ humanizeDuration .Duration }}", Inline: true},
+			{Name: "Last Success", Value: discordLastSuccess, Inline: true},
+			{Name: "Purge", Value: "{{ with .Purge }}{{ if .Error }}{{ .Error }}{{ else }}" + discordPurgeOutcome + "{{ end }}{{ else }}Skipped{{ end }}"},
+		},
+	},
+	EventPurgeSummary: {
+		Content:     "**Backup Purge {{ if .Error }}Failed{{ else if .Failed }}Completed With Errors{{ else }}Successful{{ end }}** - *{{ .Hostname }}*",
+		Title:       "Summary",
+		Description: "{{ if .Error }}{{ .Error }}{{ else }}" + discordPurgeOutcome + "{{ end }}",
+		Color:       "{{ if or .Error .Failed }}14554702{{ else }}1498748{{ end }}",
+		Footer:      discordUpdateFooter,
+		Fields: []config.DiscordTemplateFieldConfig{
+			{Name: "Remaining", Value: "{{ .Remaining }}", Inline: true},
+			{Name: "Expected", Value: "{{ .Expected }}", Inline: true},
+			{Name: "Retention", Value: "{{ .Retention }}", Inline: true},
+		},
+	},
+}
+
 func runDiscordPrechecks() error {
-	if !config.Current.Notifiers.Discord.Enabled {
+	if !config.Get().Notifiers.Discord.Enabled {
 		return ErrNotifierDisabled
 	}
 	return nil
 }
 
-func discordNotifyBackupSuccess(directory string, totalDirs, totalFiles, successFiles int, key string) {
-	if err := runDiscordPrechecks(); err != nil {
-		slog.Error("error running discord prechecks", "error", err)
-		return
+// discordTemplate merges the configured template for event over the default one.
+func discordTemplate(event string) config.DiscordTemplateConfig {
+	t := defaultDiscordTemplates[event]
+
+	custom, ok := config.Get().Notifiers.Discord.Templates[event]
+	if !ok {
+		return t
 	}
 
-	message := discord.Message{
-		Embeds: []discord.Embed{
-			{
-				Title:       "Directory",
-				Description: directory,
-				Color:       1498748,
-				Fields: []discord.EmbedField{
-					{
-						Name:   "Key",
-						Value:  key,
-						Inline: false,
-					},
-					{
-						Name:   "Dirs",
-						Value:  strconv.Itoa(totalDirs),
-						Inline: true,
-					},
-					{
-						Name:   "Files",
-						Value:  fmt.Sprintf("%d/%d", successFiles, totalFiles),
-						Inline: true,
-					},
-				},
-			},
-		},
-		Components: []discord.Component{},
-		Username:   constants.ProgramIdentifier,
-		Content:    fmt.Sprintf("**Backup Successful** - *%s*", config.Current.Backup.Hostname),
+	if custom.Content != "" {
+		t.Content = custom.Content
+	}
+	if custom.Title != "" {
+		t.Title = custom.Title
+	}
+	if custom.Description != "" {
+		t.Description = custom.Description
+	}
+	if custom.Color != "" {
+		t.Color = custom.Color
+	}
+	if custom.Footer != "" {
+		t.Footer = custom.Footer
+	}
+	if len(custom.Fields) > 0 {
+		t.Fields = custom.Fields
 	}
 
-	if version.V.NewVersionAvailable {
-		if err := message.AddFooter(version.V.GetUpdateNotification()); err != nil {
-			slog.Error("error adding footer to message", "error", err)
+	return t
+}
+
+func renderDiscordMessage(event string, t config.DiscordTemplateConfig, data any) (discord.Message, error) {
+	var err error
+	render := func(part, text string) string {
+		if err != nil {
+			return ""
 		}
+		var out string
+		out, err = renderTemplate(fmt.Sprintf("%s.%s", event, part), text, data)
+		return out
 	}
 
-	if err := message.Send(config.Current.Notifiers.Discord.Webhook); err != nil {
-		slog.Error("error sending discord message", "error", err)
+	embed := discord.Embed{
+		Title:       render("title", t.Title),
+		Description: render("description", t.Description),
 	}
-}
-
-func discordNotifyBackupFailure(directory string, totalDirs, totalFiles int, err error) {
-	if err := runDiscordPrechecks(); err != nil {
-		slog.Error("error running discord prechecks", "error", err)
-		return
+	if color := strings.TrimSpace(render("color", t.Color)); color != "" && err == nil {
+		embed.Color, err = strconv.Atoi(color)
+	}
+	for i, f := range t.Fields {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name:   render(fmt.Sprintf("fields.%d.name", i), f.Name),
+			Value:  render(fmt.Sprintf("fields.%d.value", i), f.Value),
+			Inline: f.Inline,
+		})
 	}
 
 	message := discord.Message{
-		Embeds: []discord.Embed{
-			{
-				Title:       "Error",
-				Description: err.Error(),
-				Color:       14554702,
-				Fields: []discord.EmbedField{
-					{
-						Name:   "Directory",
-						Value:  directory,
-						Inline: false,
-					},
-					{
-						Name:   "Dirs",
-						Value:  strconv.Itoa(totalDirs),
-						Inline: true,
-					},
-					{
-						Name:   "Files",
-						Value:  strconv.Itoa(totalFiles),
-						Inline: true,
-					},
-				},
-			},
-		},
+		Embeds:     []discord.Embed{embed},
 		Components: []discord.Component{},
 		Username:   constants.ProgramIdentifier,
-		Content:    fmt.Sprintf("**Backup Failed** - *%s*", config.Current.Backup.Hostname),
+		Content:    render("content", t.Content),
 	}
 
-	if version.V.NewVersionAvailable {
-		if err := message.AddFooter(version.V.GetUpdateNotification()); err != nil {
+	if footer := render("footer", t.Footer); footer != "" {
+		if err := message.AddFooter(footer); err != nil {
 			slog.Error("error adding footer to message", "error", err)
 		}
 	}
 
-	if err := message.Send(config.Current.Notifiers.Discord.Webhook); err != nil {
-		slog.Error("error sending discord message", "error", err)
-	}
+	return message, err
 }
 
-func discordNotifyBackupDeleteFailure(key string, err error) {
+func discordNotify(event string, data any) {
 	if err := runDiscordPrechecks(); err != nil {
 		slog.Error("error running discord prechecks", "error", err)
 		return
 	}
 
-	message := discord.Message{
-		Embeds: []discord.Embed{
-			{
-				Title:       "Error",
-				Description: err.Error(),
-				Color:       14590998,
-				Fields: []discord.EmbedField{
-					{
-						Name:   "Key",
-						Value:  key,
-						Inline: false,
-					},
-				},
-			},
-		},
-		Components: []discord.Component{},
-		Username:   constants.ProgramIdentifier,
-		Content:    fmt.Sprintf("**Backup Deletion Failed** - *%s*", config.Current.Backup.Hostname),
+	message, err := renderDiscordMessage(event, discordTemplate(event), data)
+	if err != nil {
+		slog.Error("error rendering discord template, using default", "event", event, "error", err)
+		if message, err = renderDiscordMessage(event, defaultDiscordTemplates[event], data); err != nil {
+			slog.Error("error rendering default discord template", "event", event, "error", err)
+			return
+		}
 	}
 
-	if version.V.NewVersionAvailable {
-		if err := message.AddFooter(version.V.GetUpdateNotification()); err != nil {
-			slog.Error("error adding footer to message", "error", err)
+	if err := message.Send(config.Get().Notifiers.Discord.Webhook); err != nil {
+		slog.Error("error sending discord message", "error", err)
+		metrics.NotifierFailuresTotal.WithLabelValues("discord").Inc()
+	}
+}
 
+func validateDiscordTemplates() error {
+	for event, t := range config.Get().Notifiers.Discord.Templates {
+		if _, ok := defaultDiscordTemplates[event]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownEvent, event)
 		}
-	}
 
-	if err := message.Send(config.Current.Notifiers.Discord.Webhook); err != nil {
-		slog.Error("error sending discord message", "error", err)
+		parts := map[string]string{
+			"content":     t.Content,
+			"color":       t.Color,
+			"title":       t.Title,
+			"description": t.Description,
+			"footer":      t.Footer,
+		}
+		for i, f := range t.Fields {
+			parts[fmt.Sprintf("fields.%d.name", i)] = f.Name
+			parts[fmt.Sprintf("fields.%d.value", i)] = f.Value
+		}
+
+		for part, text := range parts {
+			if err := parseTemplate(part, text); err != nil {
+				return fmt.Errorf("discord template %s.%s: %w", event, part, err)
+			}
+		}
 	}
+	return nil
 }
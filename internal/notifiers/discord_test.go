@@ -0,0 +1,175 @@
+package notifiers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+)
+
+// setTemplates makes templates the configured discord templates for the test.
+func setTemplates(t *testing.T, templates map[string]config.DiscordTemplateConfig) {
+	t.Helper()
+	previous := config.Get()
+	t.Cleanup(func() { config.Set(previous) })
+
+	c := &config.Config{}
+	c.Notifiers.Discord.Templates = templates
+	config.Set(c)
+}
+
+func TestDiscordTemplate(t *testing.T) {
+	def := defaultDiscordTemplates[EventBackupSuccess]
+	fields := []config.DiscordTemplateFieldConfig{{Name: "Custom", Value: "{{ .Key }}"}}
+
+	tests := []struct {
+		name      string
+		templates map[string]config.DiscordTemplateConfig
+		want      config.DiscordTemplateConfig
+	}{
+		{"no custom template", nil, def},
+		{"other event customized", map[string]config.DiscordTemplateConfig{EventBackupFailure: {Title: "x"}}, def},
+		{
+			"title only",
+			map[string]config.DiscordTemplateConfig{EventBackupSuccess: {Title: "Done"}},
+			config.DiscordTemplateConfig{Content: def.Content, Title: "Done", Description: def.Description, Color: def.Color, Footer: def.Footer, Fields: def.Fields},
+		},
+		{
+			"fields replaced",
+			map[string]config.DiscordTemplateConfig{EventBackupSuccess: {Color: "1", Fields: fields}},
+			config.DiscordTemplateConfig{Content: def.Content, Title: def.Title, Description: def.Description, Color: "1", Footer: def.Footer, Fields: fields},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTemplates(t, tt.templates)
+
+			got := discordTemplate(EventBackupSuccess)
+			if got.Content != tt.want.Content || got.Title != tt.want.Title || got.Description != tt.want.Description ||
+				got.Color != tt.want.Color || got.Footer != tt.want.Footer || len(got.Fields) != len(tt.want.Fields) {
+				t.Fatalf("discordTemplate() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got.Fields {
+				if got.Fields[i] != tt.want.Fields[i] {
+					t.Errorf("field %d = %+v, want %+v", i, got.Fields[i], tt.want.Fields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRenderDiscordMessage(t *testing.T) {
+	data := struct {
+		Name  string
+		Count int
+	}{"data", 3}
+
+	tests := []struct {
+		name        string
+		template    config.DiscordTemplateConfig
+		wantErr     bool
+		wantContent string
+		wantTitle   string
+		wantColor   int
+		wantField   string
+		wantFooter  string
+	}{
+		{
+			name: "all parts",
+			template: config.DiscordTemplateConfig{
+				Content:     "**{{ .Name }}**",
+				Title:       "Backup of {{ .Name }}",
+				Description: "{{ .Count }} files",
+				Color:       "{{ if gt .Count 1 }}1498748{{ else }}0{{ end }}",
+				Footer:      "footer {{ .Count }}",
+				Fields:      []config.DiscordTemplateFieldConfig{{Name: "Files", Value: "{{ .Count }}"}},
+			},
+			wantContent: "**data**",
+			wantTitle:   "Backup of data",
+			wantColor:   1498748,
+			wantField:   "3",
+			wantFooter:  "footer 3",
+		},
+		{name: "empty parts", template: config.DiscordTemplateConfig{Title: "Static"}, wantTitle: "Static"},
+		{name: "invalid color", template: config.DiscordTemplateConfig{Color: "red"}, wantErr: true},
+		{name: "unknown field", template: config.DiscordTemplateConfig{Title: "{{ .Missing }}"}, wantErr: true},
+		{name: "parse error", template: config.DiscordTemplateConfig{Fields: []config.DiscordTemplateFieldConfig{{Name: "{{ .Name"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := renderDiscordMessage(EventBackupSuccess, tt.template, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderDiscordMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			embed := m.Embeds[0]
+			if m.Content != tt.wantContent || embed.Title != tt.wantTitle || embed.Color != tt.wantColor || embed.Footer.Text != tt.wantFooter {
+				t.Errorf("renderDiscordMessage() = content %q title %q color %d footer %q, want %q %q %d %q",
+					m.Content, embed.Title, embed.Color, embed.Footer.Text, tt.wantContent, tt.wantTitle, tt.wantColor, tt.wantFooter)
+			}
+			if tt.wantField != "" && (len(embed.Fields) != 1 || embed.Fields[0].Value != tt.wantField) {
+				t.Errorf("fields = %+v, want value %q", embed.Fields, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestRenderDefaultDiscordTemplates(t *testing.T) {
+	tests := []struct {
+		name        string
+		summary     Summary
+		wantContent string
+	}{
+		{"successful", Summary{Hostname: "vm", Succeeded: 1, Dirs: []Event{{Directory: "/data"}}}, "**Backup Successful** - *vm*"},
+		{"with errors", Summary{Hostname: "vm", Succeeded: 1, Failed: 1, Dirs: []Event{{Directory: "/data"}, {Directory: "/etc", Error: "denied"}}}, "**Backup Completed With Errors** - *vm*"},
+		{"failed to start", Summary{Hostname: "vm", Failed: 1, Error: "backups are locked"}, "**Backup Failed** - *vm*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.summary.Duration = time.Minute
+			m, err := renderDiscordMessage(EventRunSummary, defaultDiscordTemplates[EventRunSummary], tt.summary)
+			if err != nil {
+				t.Fatalf("renderDiscordMessage() error = %v", err)
+			}
+			if m.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", m.Content, tt.wantContent)
+			}
+			if tt.summary.Error != "" && !strings.Contains(m.Embeds[0].Description, tt.summary.Error) {
+				t.Errorf("description = %q, want it to contain %q", m.Embeds[0].Description, tt.summary.Error)
+			}
+		})
+	}
+}
+
+func TestRenderPurgeSummary(t *testing.T) {
+	tests := []struct {
+		name            string
+		purge           Purge
+		wantContent     string
+		wantDescription string
+	}{
+		{"deleted", Purge{Hostname: "vm", Deleted: []string{"a", "b"}, Remaining: 3, Expected: 3}, "**Backup Purge Successful** - *vm*", "Deleted 2, remaining 3"},
+		{"nothing to delete", Purge{Hostname: "vm", Remaining: 1, Expected: 3}, "**Backup Purge Successful** - *vm*", "Deleted 0, remaining 1 (expected 3)"},
+		{"failed deletes", Purge{Hostname: "vm", Deleted: []string{"a"}, Failed: []string{"b"}, Remaining: 4, Expected: 3}, "**Backup Purge Completed With Errors** - *vm*", "Deleted 1, remaining 4, failed 1"},
+		{"failed", Purge{Hostname: "vm", Error: "access denied"}, "**Backup Purge Failed** - *vm*", "access denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := renderDiscordMessage(EventPurgeSummary, defaultDiscordTemplates[EventPurgeSummary], tt.purge)
+			if err != nil {
+				t.Fatalf("renderDiscordMessage() error = %v", err)
+			}
+			if m.Content != tt.wantContent || m.Embeds[0].Description != tt.wantDescription {
+				t.Errorf("renderDiscordMessage() = %q %q, want %q %q", m.Content, m.Embeds[0].Description, tt.wantContent, tt.wantDescription)
+			}
+		})
+	}
+}
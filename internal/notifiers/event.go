@@ -0,0 +1,193 @@
+package notifiers
+
+import (
+	"time"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/version"
+)
+
+// Notifier events. These names are used as keys when overriding templates
+// in the config, e.g. notifiers.discord.templates.backup-success.
+const (
+	EventBackupSuccess       = "backup-success"
+	EventBackupFailure       = "backup-failure"
+	EventBackupDeleteFailure = "backup-delete-failure"
+	EventPurgeSuccess        = "purge-success"
+	EventRetentionWarning    = "retention-warning"
+	EventRunSummary          = "run-summary"
+	EventPurgeSummary        = "purge-summary"
+	EventConfigReloadFailure = "config-reload-failure"
+	EventAnomalyDetected     = "anomaly-detected"
+)
+
+// Events lists every event a notifier can be templated for.
+var Events = []string{
+	EventBackupSuccess,
+	EventBackupFailure,
+	EventBackupDeleteFailure,
+	EventPurgeSuccess,
+	EventRetentionWarning,
+	EventRunSummary,
+	EventPurgeSummary,
+	EventConfigReloadFailure,
+	EventAnomalyDetected,
+}
+
+// failureEvents are sent in failures-only mode.
+var failureEvents = []string{
+	EventBackupFailure,
+	EventBackupDeleteFailure,
+	EventRetentionWarning,
+	EventConfigReloadFailure,
+	EventAnomalyDetected,
+}
+
+// summaryEvents are sent in summary mode only.
+var summaryEvents = []string{
+	EventRunSummary,
+	EventPurgeSummary,
+}
+
+// priorityEvents are sent in every mode.
+var priorityEvents = []string{
+	EventAnomalyDetected,
+}
+
+// VersionInfo describes the running version and any available update.
+type VersionInfo struct {
+	// Current is the running version, e.g. v1.2.3.
+	Current string
+	// Latest is the latest released version, if known.
+	Latest string
+	// UpdateAvailable is true when Latest differs from Current.
+	UpdateAvailable bool
+	// UpdateNotification is a ready to use update message, empty if no update is available.
+	UpdateNotification string
+}
+
+// Event is the data model exposed to notification templates. Fields that do
+// not apply to an event are left at their zero value, e.g. Error is empty
+// for backup-success.
+type Event struct {
+	// Hostname of the machine running the backup.
+	Hostname string
+	// Directory being backed up.
+	Directory string
+	// Key is the S3 key of the uploaded or deleted backup, or the config
+	// file path for config-reload-failure.
+	Key string
+	// TotalDirs is the number of directories found while walking Directory.
+	TotalDirs int
+	// TotalFiles is the number of files found while walking Directory.
+	TotalFiles int
+	// SuccessFiles is the number of files successfully backed up.
+	SuccessFiles int
+	// Size is the number of bytes uploaded.
+	Size int64
+	// Duration is how long the backup of Directory took.
+	Duration time.Duration
+	// Error is the error message for failure events.
+	Error string
+	// LastSuccess is when Directory was last backed up successfully
+	// according to the run history, zero if never.
+	LastSuccess time.Time
+	// Version holds version and update information.
+	Version VersionInfo
+}
+
+// Purge describes the outcome of purging backups exceeding the retention
+// count. It is the data model for the purge-success and retention-warning
+// templates.
+// It is also the data model of the purge-summary template sent for a purge
+// run on its own.
+type Purge struct {
+	// Hostname of the machine running the backup.
+	Hostname string
+	// Deleted lists the keys of deleted backups.
+	Deleted []string
+	// Failed lists the keys of backups that could not be deleted.
+	Failed []string
+	// Locked lists the keys of backups kept because of Object Lock.
+	Locked []string
+	// Remaining is the number of backups left after the purge.
+	Remaining int
+	// Retention is the configured retention count.
+	Retention int
+	// Expected is the number of backups expected given the schedule and
+	// retention count. Remaining below Expected triggers retention-warning.
+	Expected int
+	// Error is set when listing backups failed.
+	Error string
+	// Version holds version and update information.
+	Version VersionInfo
+}
+
+// Anomaly describes changes since the previous snapshot that look like
+// ransomware or mass deletion. It is the data model for the
+// anomaly-detected template.
+type Anomaly struct {
+	// Hostname of the machine running the backup.
+	Hostname string
+	// Key is the S3 prefix of the snapshot marked suspicious.
+	Key string
+	// Previous is the key of the snapshot the run was compared with.
+	Previous string
+	// Reasons lists the thresholds that were exceeded.
+	Reasons []string
+	// Added, Removed, Modified & Unchanged count files compared with Previous.
+	Added     int
+	Removed   int
+	Modified  int
+	Unchanged int
+	// Version holds version and update information.
+	Version VersionInfo
+}
+
+// Summary is the data model exposed to the run-summary template.
+type Summary struct {
+	// Hostname of the machine running the backup.
+	Hostname string
+	// Dirs holds one event per backed up directory, Error is set for failed ones.
+	Dirs []Event
+	// Succeeded is the number of directories backed up successfully.
+	Succeeded int
+	// Failed is the number of directories that failed to back up, including
+	// those that never ran because the run failed.
+	Failed int
+	// Error is set when the run failed outside of any directory, e.g. it
+	// could not start.
+	Error string
+	// Purge is the purge outcome, nil if no purge was performed.
+	Purge *Purge
+	// Duration is how long the whole run took.
+	Duration time.Duration
+	// LastSuccess is when a previous run last succeeded according to the
+	// run history, zero if never.
+	LastSuccess time.Time
+	// Version holds version and update information.
+	Version VersionInfo
+}
+
+// newEvent fills the fields common to every event.
+func newEvent(e Event) Event {
+	e.Hostname = config.Get().Backup.Hostname
+	e.Version = versionInfo()
+	return e
+}
+
+// newPurge fills the fields common to every purge event.
+func newPurge(p Purge) Purge {
+	p.Hostname = config.Get().Backup.Hostname
+	p.Version = versionInfo()
+	return p
+}
+
+func versionInfo() VersionInfo {
+	return VersionInfo{
+		Current:            version.V.CurrentVersion,
+		Latest:             version.V.LatestVersion,
+		UpdateAvailable:    version.V.NewVersionAvailable,
+		UpdateNotification: version.V.GetUpdateNotification(),
+	}
+}
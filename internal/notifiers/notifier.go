@@ -3,49 +3,179 @@ package notifiers
 import (
 	"errors"
 	"log/slog"
+	"slices"
 
 	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
 )
 
 var (
 	ErrNotifiersDisabled      = errors.New("notifiers are disabled")
 	ErrMissingNotifierWebhook = errors.New("missing notifier webhook")
 	ErrNotifierDisabled       = errors.New("notifier is disabled")
+	ErrUnknownEvent           = errors.New("unknown notifier event")
 )
 
 func runPreChecks() error {
-	if !config.Current.Notifiers.Enabled {
+	if !config.Get().Notifiers.Enabled {
 		return ErrNotifiersDisabled
 	}
 
 	return nil
 }
 
-func NotifyBackupSuccess(directory string, totalDirs, totalFiles, successFiles int, key string) {
+// enabledForMode reports whether event is sent in the configured notifier mode.
+func enabledForMode(event string) bool {
+	if slices.Contains(priorityEvents, event) {
+		return true
+	}
+
+	switch config.Get().Notifiers.Mode {
+	case constants.NotifierModeSummary:
+		return slices.Contains(summaryEvents, event)
+	case constants.NotifierModeFailuresOnly:
+		return slices.Contains(failureEvents, event)
+	default:
+		return !slices.Contains(summaryEvents, event)
+	}
+}
+
+// ValidateTemplates checks that all configured notifier templates parse and
+// refer to known events.
+func ValidateTemplates() error {
+	return validateDiscordTemplates()
+}
+
+func NotifyBackupSuccess(event Event) {
+	if !enabledForMode(EventBackupSuccess) {
+		return
+	}
+
 	if err := runPreChecks(); err != nil {
 		slog.Error("error running prechecks", "error", err)
 		return
 	}
 
-	discordNotifyBackupSuccess(directory, totalDirs, totalFiles, successFiles, key)
+	discordNotify(EventBackupSuccess, newEvent(event))
 
 }
 
-func NotifyBackupFailure(directory string, totalDirs, totalFiles int, err error) {
+func NotifyBackupFailure(event Event, err error) {
+	if !enabledForMode(EventBackupFailure) {
+		return
+	}
+
 	if err := runPreChecks(); err != nil {
 		slog.Error("error running prechecks", "error", err)
 		return
 	}
 
-	discordNotifyBackupFailure(directory, totalDirs, totalFiles, err)
+	event.Error = err.Error()
+	discordNotify(EventBackupFailure, newEvent(event))
 
 }
 
 func NotifyBackupDeleteFailure(key string, err error) {
+	if !enabledForMode(EventBackupDeleteFailure) {
+		return
+	}
+
+	if err := runPreChecks(); err != nil {
+		slog.Error("error running prechecks", "error", err)
+		return
+	}
+
+	discordNotify(EventBackupDeleteFailure, newEvent(Event{Key: key, Error: err.Error()}))
+}
+
+func NotifyPurgeSuccess(purge Purge) {
+	if !enabledForMode(EventPurgeSuccess) {
+		return
+	}
+
+	if err := runPreChecks(); err != nil {
+		slog.Error("error running prechecks", "error", err)
+		return
+	}
+
+	discordNotify(EventPurgeSuccess, newPurge(purge))
+}
+
+func NotifyRetentionWarning(purge Purge) {
+	if !enabledForMode(EventRetentionWarning) {
+		return
+	}
+
+	if err := runPreChecks(); err != nil {
+		slog.Error("error running prechecks", "error", err)
+		return
+	}
+
+	discordNotify(EventRetentionWarning, newPurge(purge))
+}
+
+func NotifyRunSummary(summary Summary) {
+	if !enabledForMode(EventRunSummary) {
+		return
+	}
+
+	if err := runPreChecks(); err != nil {
+		slog.Error("error running prechecks", "error", err)
+		return
+	}
+
+	summary.Hostname = config.Get().Backup.Hostname
+	summary.Version = versionInfo()
+	for i, e := range summary.Dirs {
+		summary.Dirs[i] = newEvent(e)
+	}
+	if summary.Purge != nil {
+		p := newPurge(*summary.Purge)
+		summary.Purge = &p
+	}
+
+	discordNotify(EventRunSummary, summary)
+}
+
+// NotifyPurgeSummary sends the summary of a purge run on its own, without
+// backups.
+func NotifyPurgeSummary(purge Purge) {
+	if !enabledForMode(EventPurgeSummary) {
+		return
+	}
+
+	if err := runPreChecks(); err != nil {
+		slog.Error("error running prechecks", "error", err)
+		return
+	}
+
+	discordNotify(EventPurgeSummary, newPurge(purge))
+}
+
+// NotifyConfigReloadFailure notifies that the config file at path could not
+// be reloaded and the previous config is kept.
+func NotifyConfigReloadFailure(path string, err error) {
+	if !enabledForMode(EventConfigReloadFailure) {
+		return
+	}
+
+	if err := runPreChecks(); err != nil {
+		slog.Error("error running prechecks", "error", err)
+		return
+	}
+
+	discordNotify(EventConfigReloadFailure, newEvent(Event{Key: path, Error: err.Error()}))
+}
+
+// NotifyAnomaly notifies that a backup looks like ransomware or mass
+// deletion. It is sent in every notifier mode.
+func NotifyAnomaly(anomaly Anomaly) {
 	if err := runPreChecks(); err != nil {
 		slog.Error("error running prechecks", "error", err)
 		return
 	}
 
-	discordNotifyBackupDeleteFailure(key, err)
+	anomaly.Hostname = config.Get().Backup.Hostname
+	anomaly.Version = versionInfo()
+	discordNotify(EventAnomalyDetected, anomaly)
 }
@@ -0,0 +1,45 @@
+package notifiers
+
+import (
+	"testing"
+
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+)
+
+func TestEnabledForMode(t *testing.T) {
+	tests := []struct {
+		mode  string
+		event string
+		want  bool
+	}{
+		{constants.NotifierModePerDir, EventBackupSuccess, true},
+		{constants.NotifierModePerDir, EventPurgeSuccess, true},
+		{constants.NotifierModePerDir, EventRunSummary, false},
+		{constants.NotifierModePerDir, EventPurgeSummary, false},
+		{constants.NotifierModeSummary, EventBackupSuccess, false},
+		{constants.NotifierModeSummary, EventPurgeSuccess, false},
+		{constants.NotifierModeSummary, EventRunSummary, true},
+		{constants.NotifierModeSummary, EventPurgeSummary, true},
+		{constants.NotifierModeFailuresOnly, EventBackupFailure, true},
+		{constants.NotifierModeFailuresOnly, EventRunSummary, false},
+		{constants.NotifierModeFailuresOnly, EventPurgeSummary, false},
+		{constants.NotifierModeFailuresOnly, EventAnomalyDetected, true},
+		{constants.NotifierModeSummary, EventAnomalyDetected, true},
+	}
+
+	previous := config.Get()
+	t.Cleanup(func() { config.Set(previous) })
+
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.event, func(t *testing.T) {
+			c := &config.Config{}
+			c.Notifiers.Mode = tt.mode
+			config.Set(c)
+
+			if got := enabledForMode(tt.event); got != tt.want {
+				t.Errorf("enabledForMode(%s) = %v, want %v", tt.event, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package notifiers
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+var templateFuncs = template.FuncMap{
+	"humanizeBytes":    humanizeBytes,
+	"humanizeDuration": humanizeDuration,
+	"since":            time.Since,
+}
+
+// humanizeDuration rounds d to minutes for durations of an hour or more,
+// seconds below that, or milliseconds for sub-second durations.
+func humanizeDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		return d.Round(time.Minute).String()
+	case d >= time.Second:
+		return d.Round(time.Second).String()
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
+
+// humanizeBytes formats a byte count using binary units, e.g. 1.5 MiB.
+func humanizeBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// renderTemplate executes text as a Go template against data.
+func renderTemplate(name, text string, data any) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// parseTemplate checks that text is a valid template.
+func parseTemplate(name, text string) error {
+	_, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	return err
+}
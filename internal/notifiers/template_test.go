@@ -0,0 +1,45 @@
+package notifiers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{1234567 * time.Microsecond, "1s"},
+		{1500 * time.Microsecond, "2ms"},
+		{90*time.Second + 400*time.Millisecond, "1m30s"},
+		{2*time.Hour + 29*time.Second, "2h0m0s"},
+		{2*time.Hour + 31*time.Second, "2h1m0s"},
+	}
+
+	for _, tt := range tests {
+		if got := humanizeDuration(tt.in); got != tt.want {
+			t.Errorf("humanizeDuration(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+
+	for _, tt := range tests {
+		if got := humanizeBytes(tt.in); got != tt.want {
+			t.Errorf("humanizeBytes(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	commonGPG "github.com/hibare/GoCommon/v2/pkg/crypto/gpg"
+	commonLogger "github.com/hibare/GoCommon/v2/pkg/logger"
+	commonUtils "github.com/hibare/GoCommon/v2/pkg/utils"
+	"github.com/hibare/GoS3Backup/internal/backup"
+	"github.com/hibare/GoS3Backup/internal/config"
+	"github.com/hibare/GoS3Backup/internal/constants"
+	"github.com/hibare/GoS3Backup/internal/notifiers"
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	ErrNotDir      = errors.New("not a directory")
+	ErrMissingHost = errors.New("missing host")
+)
+
+// Check statuses.
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+type report []Result
+
+func (r *report) pass(name, detail string) {
+	*r = append(*r, Result{Name: name, Status: StatusPass, Detail: detail})
+}
+
+func (r *report) warn(name, detail string) {
+	*r = append(*r, Result{Name: name, Status: StatusWarn, Detail: detail})
+}
+
+func (r *report) fail(name, detail string) {
+	*r = append(*r, Result{Name: name, Status: StatusFail, Detail: detail})
+}
+
+// Failed reports whether any check failed.
+func Failed(results []Result) bool {
+	return slices.ContainsFunc(results, func(r Result) bool {
+		return r.Status == StatusFail
+	})
+}
+
+// Run parses the config file and checks it, including reachability of the
+// bucket, GPG key server and webhooks. Settings that LoadConfig would
+// silently rewrite or disable are reported as failures.
+func Run() []Result {
+	var r report
+
+	c, err := config.ReadConfig()
+	if err != nil {
+		r.fail("config file", err.Error())
+		return r
+	}
+	r.pass("config file", config.BC.ConfigFilePath)
+
+	if v := c.FileVersion(); v < constants.ConfigVersion {
+		r.warn("version", fmt.Sprintf("schema version %d, run `config migrate --write` to upgrade to %d", v, constants.ConfigVersion))
+	}
+
+	c.Backup.Hostname = commonUtils.GetHostname()
+	config.Set(c)
+
+	checkLogger(&r, c)
+	checkSchedule(&r, c)
+	checkDateTimeLayout(&r, c)
+	checkDirs(&r, c)
+	checkAnomaly(&r, c)
+	checkS3(&r, c)
+	checkObjectLock(&r, c)
+	checkEncryption(&r, c)
+	checkNotifiers(&r, c)
+	checkHeartbeat(&r, c)
+	checkAPI(&r, c)
+
+	return r
+}
+
+func checkLogger(r *report, c *config.Config) {
+	if c.Logger.Level != "" && !commonLogger.IsValidLogLevel(c.Logger.Level) {
+		r.fail("logger.level", fmt.Sprintf("invalid level %q", c.Logger.Level))
+	}
+	if c.Logger.Mode != "" && !commonLogger.IsValidLogMode(c.Logger.Mode) {
+		r.fail("logger.mode", fmt.Sprintf("invalid mode %q", c.Logger.Mode))
+	}
+}
+
+func checkSchedule(r *report, c *config.Config) {
+	tz := c.Backup.Timezone
+	if tz == "" {
+		tz = constants.DefaultTimezone
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		r.fail("backup.timezone", err.Error())
+		tz = constants.DefaultTimezone
+	}
+
+	expr := c.Backup.Cron
+	if expr == "" {
+		expr = constants.DefaultCron
+	}
+
+	schedule, err := Cron(expr, tz)
+	if err != nil {
+		r.fail("backup.cron", err.Error())
+		return
+	}
+
+	next := schedule.Next(time.Now()).Format(time.RFC3339)
+	if c.Backup.Cron == "" {
+		r.warn("backup.cron", fmt.Sprintf("not set, default %q is used, next run at %s", constants.DefaultCron, next))
+	} else {
+		r.pass("backup.cron", fmt.Sprintf("next run at %s", next))
+	}
+
+	if c.Backup.Jitter < 0 {
+		r.fail("backup.jitter", "must not be negative")
+	}
+}
+
+func checkDateTimeLayout(r *report, c *config.Config) {
+	layout := c.Backup.DateTimeLayout
+	if layout == "" {
+		r.warn("backup.date-time-layout", fmt.Sprintf("not set, default %q is used", constants.DefaultDateTimeLayout))
+		return
+	}
+
+	if err := DateTimeLayout(layout); err != nil {
+		r.fail("backup.date-time-layout", err.Error())
+		return
+	}
+	r.pass("backup.date-time-layout", layout)
+}
+
+func checkDirs(r *report, c *config.Config) {
+	if len(c.Backup.Dirs) == 0 {
+		r.fail("backup.dirs", "no dirs configured")
+		return
+	}
+
+	for _, dir := range c.Backup.Dirs {
+		name := fmt.Sprintf("backup.dirs %s", dir)
+		if err := Dir(dir); err != nil {
+			r.fail(name, err.Error())
+			continue
+		}
+		r.pass(name, "readable")
+	}
+
+	if c.Backup.Retention.Count < 0 {
+		r.fail("backup.retention.count", "must not be negative")
+	}
+}
+
+func checkS3(r *report, c *config.Config) {
+	if c.S3.Bucket == "" {
+		r.fail("s3.bucket", "not set")
+		return
+	}
+
+	auth := &c.S3.Auth
+	if auth.Type == "" {
+		auth.Type = constants.DefaultS3AuthType
+	}
+	if auth.SessionName == "" {
+		auth.SessionName = constants.DefaultRoleSessionName
+	}
+	switch {
+	case !slices.Contains(constants.S3AuthTypes, auth.Type):
+		r.fail("s3.auth.type", fmt.Sprintf("invalid type %q, expected one of %v", auth.Type, constants.S3AuthTypes))
+		return
+	case auth.Type == constants.S3AuthStatic && (c.S3.AccessKey == "" || c.S3.SecretKey == ""):
+		r.fail("s3.auth", "static auth requires access-key and secret-key")
+		return
+	case auth.Type == constants.S3AuthAssumeRole && auth.RoleARN == "":
+		r.fail("s3.auth.role-arn", fmt.Sprintf("required for %s", auth.Type))
+		return
+	}
+	r.pass("s3.auth", auth.Type)
+
+	if err := backup.Ping(); err != nil {
+		r.fail("s3 bucket", err.Error())
+		return
+	}
+	r.pass("s3 bucket", fmt.Sprintf("%s is reachable", c.S3.Bucket))
+
+	if err := backup.Probe(); err != nil {
+		r.fail("s3 write/delete", err.Error())
+		return
+	}
+	r.pass("s3 write/delete", "probe object written and deleted")
+}
+
+func checkObjectLock(r *report, c *config.Config) {
+	if err := c.Backup.ObjectLock.Check(); err != nil {
+		r.fail("backup.object-lock.mode", err.Error())
+		return
+	}
+	if !c.Backup.ObjectLock.Enabled {
+		return
+	}
+	if err := backup.CheckObjectLock(); err != nil {
+		r.fail("backup.object-lock", err.Error())
+		return
+	}
+	r.pass("backup.object-lock", fmt.Sprintf("enabled on %s", c.S3.Bucket))
+}
+
+func checkEncryption(r *report, c *config.Config) {
+	e := c.Backup.Encryption
+	if !e.Enabled {
+		return
+	}
+
+	if !c.Backup.ArchiveDirs {
+		r.fail("backup.encryption", "requires backup.archive-dirs, encryption would be disabled")
+		return
+	}
+	if e.GPG.KeyServer == "" || e.GPG.KeyID == "" {
+		r.fail("backup.encryption.gpg", "key-server and key-id are required, encryption would be disabled")
+		return
+	}
+
+	gpg, err := commonGPG.DownloadGPGPubKey(e.GPG.KeyID, e.GPG.KeyServer)
+	if err != nil {
+		r.fail("backup.encryption.gpg", fmt.Sprintf("downloading key %s: %s", e.GPG.KeyID, err))
+		return
+	}
+	os.Remove(gpg.PublicKeyPath)
+	r.pass("backup.encryption.gpg", fmt.Sprintf("key %s downloaded", e.GPG.KeyID))
+}
+
+func checkNotifiers(r *report, c *config.Config) {
+	if c.Notifiers.Mode != "" && !slices.Contains(constants.NotifierModes, c.Notifiers.Mode) {
+		r.fail("notifiers.mode", fmt.Sprintf("invalid mode %q, expected one of %v", c.Notifiers.Mode, constants.NotifierModes))
+	}
+
+	d := c.Notifiers.Discord
+	if !d.Enabled {
+		return
+	}
+
+	if d.Webhook == "" {
+		r.fail("notifiers.discord.webhook", "not set, discord would be disabled")
+		return
+	}
+	if err := URL(d.Webhook); err != nil {
+		r.fail("notifiers.discord.webhook", err.Error())
+		return
+	}
+	r.pass("notifiers.discord.webhook", "valid URL")
+
+	if err := notifiers.ValidateTemplates(); err != nil {
+		r.fail("notifiers.discord.templates", err.Error())
+		return
+	}
+	r.pass("notifiers.discord.templates", "valid")
+}
+
+func checkHeartbeat(r *report, c *config.Config) {
+	h := c.Heartbeat
+	if h.Type != "" && !slices.Contains(constants.HeartbeatTypes, h.Type) {
+		r.fail("heartbeat.type", fmt.Sprintf("invalid type %q, expected one of %v", h.Type, constants.HeartbeatTypes))
+	}
+
+	if !h.Enabled {
+		return
+	}
+
+	if h.URL == "" {
+		r.fail("heartbeat.url", "not set, heartbeat would be disabled")
+		return
+	}
+	if err := URL(h.URL); err != nil {
+		r.fail("heartbeat.url", err.Error())
+		return
+	}
+	r.pass("heartbeat.url", "valid URL")
+}
+
+func checkAnomaly(r *report, c *config.Config) {
+	if err := c.Backup.Anomaly.Check(); err != nil {
+		r.fail("backup.anomaly", err.Error())
+		return
+	}
+	if c.Backup.Anomaly.Enabled && c.Backup.Encryption.Enabled {
+		r.warn("backup.anomaly", fmt.Sprintf("encrypted snapshots are compared with the local file index in %s, runs after it is lost are not checked", filepath.Join(config.BC.ConfigRootDir, constants.IndexDirName)))
+	}
+}
+
+func checkAPI(r *report, c *config.Config) {
+	if c.API.Enabled && c.API.Token == "" {
+		r.fail("api.token", "not set, API would be disabled")
+	}
+}
+
+// Cron parses expr like the scheduler does in the timezone tz.
+func Cron(expr, tz string) (cron.Schedule, error) {
+	return cron.ParseStandard(fmt.Sprintf("CRON_TZ=%s %s", tz, expr))
+}
+
+// DateTimeLayout checks that layout formats and parses back times with
+// second precision, which is needed to tell snapshots apart.
+func DateTimeLayout(layout string) error {
+	want := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	got, err := time.Parse(layout, want.Format(layout))
+	if err != nil {
+		return fmt.Errorf("does not parse back: %w", err)
+	}
+	if !got.Equal(want) {
+		return fmt.Errorf("does not round-trip, %s became %s", want.Format(time.DateTime), got.Format(time.DateTime))
+	}
+	return nil
+}
+
+// Dir checks that dir exists, is a directory and can be read.
+func Dir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return ErrNotDir
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// URL checks that s is an absolute http(s) URL.
+func URL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return ErrMissingHost
+	}
+	return nil
+}
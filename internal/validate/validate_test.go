@@ -0,0 +1,50 @@
+package validate
+
+import "testing"
+
+func TestDateTimeLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  string
+		wantErr bool
+	}{
+		{"default", "20060102150405", false},
+		{"rfc3339", "2006-01-02T15:04:05Z07:00", false},
+		{"with separators", "2006-01-02_15-04-05", false},
+		{"12 hour clock", "2006-01-02 03:04:05PM", false},
+		{"no seconds", "200601021504", true},
+		{"date only", "2006-01-02", true},
+		{"12 hour clock without am/pm", "2006-01-02 03:04:05", true},
+		{"literal text", "backup", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := DateTimeLayout(tt.layout); (err != nil) != tt.wantErr {
+				t.Errorf("DateTimeLayout(%q) error = %v, wantErr %v", tt.layout, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCron(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		tz      string
+		wantErr bool
+	}{
+		{"daily", "0 0 * * *", "UTC", false},
+		{"descriptor", "@hourly", "Europe/Berlin", false},
+		{"too few fields", "0 0 *", "UTC", true},
+		{"unknown timezone", "0 0 * * *", "Mars/Olympus", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Cron(tt.expr, tt.tz); (err != nil) != tt.wantErr {
+				t.Errorf("Cron(%q, %q) error = %v, wantErr %v", tt.expr, tt.tz, err, tt.wantErr)
+			}
+		})
+	}
+}